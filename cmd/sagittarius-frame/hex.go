@@ -0,0 +1,37 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// xxd: "00000000: 5347 0101 ...  SG.."
+	_xxdRe = regexp.MustCompile(`^[0-9a-fA-F]+:\s+(.*)$`)
+	// hexdump -C: "00000000  53 47 01 01 ...  |SG..|"
+	_hexdumpRe = regexp.MustCompile(`^[0-9a-fA-F]{8}\s{2}(.*?)\s*\|.*\|\s*$`)
+)
+
+// cleanHex 去掉偏移量、ASCII 列、0x 前缀及分隔符, 只保留十六进制字符
+func cleanHex(s string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if m := _hexdumpRe.FindStringSubmatch(line); m != nil {
+			line = m[1]
+		} else if m := _xxdRe.FindStringSubmatch(line); m != nil {
+			line = m[1]
+			// ASCII 列与十六进制之间为两个空格
+			if i := strings.Index(line, "  "); i >= 0 {
+				line = line[:i]
+			}
+		}
+		line = strings.Replace(line, "0x", "", -1)
+		for _, r := range line {
+			if strings.ContainsRune("0123456789abcdefABCDEF", r) {
+				b.WriteRune(r)
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCleanHex(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "53470100", "53470100"},
+		{"spaced", "53 47 01 00\n0a 0b", "534701000a0b"},
+		{"prefixed", "0x53, 0x47, 0x01", "534701"},
+		{"xxd", "00000000: 5347 0101 0000 0000  SG......\n00000008: 0a0b                                    ..", "53470101000000000a0b"},
+		{"hexdump", "00000000  53 47 01 01 00 00 00 00  00 00 00 02 7b 7d 00 00  |SG..........{}..|\n00000010  0a                                                |.|", "5347010100000000000000027b7d00000a"},
+		{"ascii column with hex letters", "00000000: 5347  SGabcdef", "5347"},
+	}
+	for _, tt := range tests {
+		if got := cleanHex(tt.in); got != tt.want {
+			t.Errorf("%s: want %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// sagittarius-frame 解码抓包得到的 tcp 帧, 或将其回放至本地 Engine
+//
+//	sagittarius-frame decode [-hex] [file]
+//	sagittarius-frame replay -addr 127.0.0.1:8080 [-hex] [-interval 10ms] [file]
+//
+// file 缺省时读取标准输入; -hex 接受纯十六进制、xxd 及 hexdump -C 格式.
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/opentracing/opentracing-go"
+	"github.com/ousanki/sagittarius/server/tcp"
+	"github.com/uber/jaeger-client-go"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+	"unicode/utf8"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	// 帧内 trace 为 jaeger 二进制格式
+	tracer, closer := jaeger.NewTracer("sagittarius-frame", jaeger.NewConstSampler(false), jaeger.NewNullReporter())
+	defer closer.Close()
+	opentracing.SetGlobalTracer(tracer)
+
+	var err error
+	switch os.Args[1] {
+	case "decode":
+		err = decode(os.Args[2:])
+	case "replay":
+		err = replay(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "sagittarius-frame:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: sagittarius-frame decode|replay [flags] [file]")
+	os.Exit(2)
+}
+
+func decode(args []string) error {
+	fs := flag.NewFlagSet("decode", flag.ExitOnError)
+	isHex := fs.Bool("hex", false, "input is a hex dump")
+	fs.Parse(args)
+
+	data, err := load(fs.Arg(0), *isHex)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return eachFrame(data, func(offset int, f *tcp.Frame) error {
+		return enc.Encode(view(offset, f))
+	})
+}
+
+func replay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	isHex := fs.Bool("hex", false, "input is a hex dump")
+	addr := fs.String("addr", "127.0.0.1:8080", "engine address")
+	interval := fs.Duration("interval", 0, "pause between frames")
+	wait := fs.Duration("wait", time.Second, "time to wait for replies after the last frame")
+	fs.Parse(args)
+
+	data, err := load(fs.Arg(0), *isHex)
+	if err != nil {
+		return err
+	}
+	var frames []*tcp.Frame
+	err = eachFrame(data, func(offset int, f *tcp.Frame) error {
+		frames = append(frames, f)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c, err := net.Dial("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	// 打印回包
+	go func() {
+		enc := json.NewEncoder(os.Stdout)
+		var offset int
+		for {
+			f, err := tcp.DecodeFrame(c)
+			if err != nil {
+				return
+			}
+			v := view(offset, f)
+			v.Direction = "recv"
+			enc.Encode(v)
+			offset += len(f.Raw)
+		}
+	}()
+	for i, f := range frames {
+		if i > 0 && *interval > 0 {
+			time.Sleep(*interval)
+		}
+		if _, err = c.Write(f.Raw); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "sent frame %d id:%d len:%d\n", i, f.ID, len(f.Raw))
+	}
+	time.Sleep(*wait)
+	return nil
+}
+
+func load(file string, isHex bool) ([]byte, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if file == "" || file == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(file)
+	}
+	if err != nil || !isHex {
+		return data, err
+	}
+	return hex.DecodeString(cleanHex(string(data)))
+}
+
+func eachFrame(data []byte, fn func(offset int, f *tcp.Frame) error) error {
+	r := bytes.NewReader(data)
+	var offset int
+	for r.Len() > 0 {
+		f, err := tcp.DecodeFrame(r)
+		if fe, ok := err.(*tcp.FrameError); ok {
+			if fe.Err == io.ErrUnexpectedEOF || fe.Err == io.EOF {
+				return fmt.Errorf("truncated frame at offset %d, %d bytes left", offset, fe.Offset)
+			}
+			return fmt.Errorf("frame at offset %d: %v at offset %d", offset, fe.Err, offset+fe.Offset)
+		}
+		if err != nil {
+			return fmt.Errorf("offset %d: %v", offset, err)
+		}
+		if err = fn(offset, f); err != nil {
+			return err
+		}
+		offset += len(f.Raw)
+	}
+	return nil
+}
+
+type frameView struct {
+	Direction string                 `json:"direction,omitempty"`
+	Offset    int                    `json:"offset"`
+	Length    int                    `json:"length"`
+	Version   uint8                  `json:"version"`
+	Flags     []string               `json:"flags,omitempty"`
+	ID        int64                  `json:"id"`
+	Trace     string                 `json:"trace,omitempty"`
+	Stream    *streamView            `json:"stream,omitempty"`
+	Header    map[string]interface{} `json:"header"`
+	Body      json.RawMessage        `json:"body,omitempty"`
+	BodyText  string                 `json:"body_text,omitempty"`
+	BodyHex   string                 `json:"body_hex,omitempty"`
+}
+
+type streamView struct {
+	ID     int64  `json:"id"`
+	Kind   string `json:"kind"`
+	Window int64  `json:"window,omitempty"`
+}
+
+func view(offset int, f *tcp.Frame) frameView {
+	v := frameView{
+		Offset:  offset,
+		Length:  len(f.Raw),
+		Version: f.Version,
+		Flags:   f.Flags(),
+		ID:      f.ID,
+		Trace:   f.Trace,
+		Header:  f.Header,
+	}
+	if f.WithTrace == tcp.UseTracer && v.Trace == "" {
+		v.Trace = "unsampled"
+	}
+	if f.IsStream() {
+		v.Stream = &streamView{
+			ID:     f.StreamID,
+			Kind:   tcp.StreamKindName(f.StreamKind),
+			Window: f.Window,
+		}
+	}
+	switch {
+	case len(f.Body) == 0:
+	case json.Valid(f.Body):
+		v.Body = f.Body
+	case utf8.Valid(f.Body):
+		v.BodyText = string(f.Body)
+	default:
+		v.BodyHex = hex.EncodeToString(f.Body)
+	}
+	return v
+}
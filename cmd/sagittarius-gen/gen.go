@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"go/format"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+type Service struct {
+	Source   string
+	Package  string
+	Name     string
+	Messages []Message
+	Methods  []Method
+}
+
+type Message struct {
+	Name   string
+	Fields []Field
+}
+
+type Field struct {
+	Name string
+	Type string
+	Tag  string
+}
+
+type Method struct {
+	Name string
+	ID   int64
+	// 以方法名注册时非空
+	Named    string
+	Request  string
+	Response string
+	// server / client / bidi, 普通请求为空
+	Stream string
+}
+
+func (m Method) StreamRegister() string {
+	switch m.Stream {
+	case "server":
+		return "ServerStream"
+	case "client":
+		return "ClientStream"
+	default:
+		return "BidiStream"
+	}
+}
+
+// ResponseElem 指针类型回包去掉 '*'
+func (m Method) ResponseElem() string {
+	return strings.TrimPrefix(m.Response, "*")
+}
+
+func (m Method) RequestElem() string {
+	return strings.TrimPrefix(m.Request, "*")
+}
+
+// TypedStream IDL 中的流携带消息类型, 生成强类型的流; 由 Go 源码扫描得到的流没有类型
+func (m Method) TypedStream() bool {
+	return m.Stream != "" && strings.HasPrefix(m.Request, "*") && strings.HasPrefix(m.Response, "*")
+}
+
+func (m Method) ResponsePtr() bool {
+	return strings.HasPrefix(m.Response, "*")
+}
+
+var _tmpl = template.Must(template.New("gen").Parse(`// Code generated by sagittarius-gen. DO NOT EDIT.
+// source: {{.Source}}
+
+package {{.Package}}
+
+import (
+	"context"
+	"github.com/ousanki/sagittarius/server/tcp"
+)
+
+// 路由 ID
+const (
+{{- range .Methods}}
+	{{$.Name}}{{.Name}}ID int64 = {{.ID}}{{if .Named}} // {{.Named}}{{end}}
+{{- end}}
+)
+{{range .Messages}}
+type {{.Name}} struct {
+{{- range .Fields}}
+	{{.Name}} {{.Type}} ` + "`json:\"{{.Tag}}\"`" + `
+{{- end}}
+}
+{{end}}
+{{- range .Methods}}
+{{- if .TypedStream}}
+// {{$.Name}}{{.Name}}ServerStream 服务端流, 接收 {{.Request}}, 发送 {{.Response}}
+type {{$.Name}}{{.Name}}ServerStream struct {
+	*tcp.Stream
+}
+
+func (s *{{$.Name}}{{.Name}}ServerStream) Send(resp {{.Response}}) error {
+	return s.Stream.Send(resp)
+}
+
+func (s *{{$.Name}}{{.Name}}ServerStream) Recv() ({{.Request}}, error) {
+	req := new({{.RequestElem}})
+	if err := s.Stream.Recv(req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// {{$.Name}}{{.Name}}ClientStream 客户端流, 发送 {{.Request}}, 接收 {{.Response}}
+type {{$.Name}}{{.Name}}ClientStream struct {
+	*tcp.ClientStream
+}
+
+func (s *{{$.Name}}{{.Name}}ClientStream) Send(req {{.Request}}) error {
+	return s.ClientStream.Send(req)
+}
+
+func (s *{{$.Name}}{{.Name}}ClientStream) Recv() ({{.Response}}, error) {
+	resp := new({{.ResponseElem}})
+	if err := s.ClientStream.Recv(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+{{end}}
+{{- end}}
+// {{.Name}}Server 服务端实现
+type {{.Name}}Server interface {
+{{- range .Methods}}
+{{- if .TypedStream}}
+	{{.Name}}(*{{$.Name}}{{.Name}}ServerStream) error
+{{- else if .Stream}}
+	{{.Name}}(*tcp.Stream) error
+{{- else}}
+	{{.Name}}(*tcp.Context, {{.Request}}) ({{.Response}}, error)
+{{- end}}
+{{- end}}
+}
+
+// Register{{.Name}}Server 注册至 Group
+func Register{{.Name}}Server(g *tcp.Group, srv {{.Name}}Server) {
+{{- range .Methods}}
+{{- if .TypedStream}}
+	g.{{.StreamRegister}}({{$.Name}}{{.Name}}ID, func(st *tcp.Stream) error {
+		return srv.{{.Name}}(&{{$.Name}}{{.Name}}ServerStream{Stream: st})
+	})
+{{- else if .Stream}}
+	g.{{.StreamRegister}}({{$.Name}}{{.Name}}ID, srv.{{.Name}})
+{{- else if .Named}}
+	g.HandleNamed("{{.Named}}", srv.{{.Name}})
+{{- else}}
+	g.Handle({{$.Name}}{{.Name}}ID, srv.{{.Name}})
+{{- end}}
+{{- end}}
+}
+
+// {{.Name}}Client 客户端
+type {{.Name}}Client struct {
+	cl *tcp.Client
+}
+
+func New{{.Name}}Client(cl *tcp.Client) *{{.Name}}Client {
+	return &{{.Name}}Client{cl: cl}
+}
+{{range .Methods}}
+{{- if .TypedStream}}
+func (c *{{$.Name}}Client) {{.Name}}(ctx context.Context) (*{{$.Name}}{{.Name}}ClientStream, error) {
+	st, err := c.cl.OpenStream(ctx, {{$.Name}}{{.Name}}ID, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &{{$.Name}}{{.Name}}ClientStream{ClientStream: st}, nil
+}
+{{else if .Stream}}
+func (c *{{$.Name}}Client) {{.Name}}(ctx context.Context) (*tcp.ClientStream, error) {
+	return c.cl.OpenStream(ctx, {{$.Name}}{{.Name}}ID, nil)
+}
+{{else if .ResponsePtr}}
+func (c *{{$.Name}}Client) {{.Name}}(ctx context.Context, req {{.Request}}) ({{.Response}}, error) {
+	resp := new({{.ResponseElem}})
+	if err := c.cl.Call(ctx, {{$.Name}}{{.Name}}ID, req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+{{else}}
+func (c *{{$.Name}}Client) {{.Name}}(ctx context.Context, req {{.Request}}) ({{.Response}}, error) {
+	var resp {{.Response}}
+	err := c.cl.Call(ctx, {{$.Name}}{{.Name}}ID, req, &resp)
+	return resp, err
+}
+{{end}}
+{{- end}}`))
+
+func generate(svc *Service) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := _tmpl.Execute(&buf, svc); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
+
+// exported snake_case / lowerCamel 转为导出名
+func exported(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
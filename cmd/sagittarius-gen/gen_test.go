@@ -0,0 +1,134 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const _testIDL = `package user
+
+message LoginReq {
+    uid   int64
+}
+
+message FeedMsg {
+    text string
+}
+
+service User {
+    rpc Login(LoginReq) FeedMsg = 1001
+    stream Feed(LoginReq) FeedMsg = "user.feed" server
+}
+`
+
+func TestGenerateIDL(t *testing.T) {
+	svc, err := parseIDL(strings.NewReader(_testIDL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.Name = "User"
+	src, err := generate(svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Feed(*UserFeedServerStream) error",
+		"func (s *UserFeedClientStream) Recv() (*FeedMsg, error)",
+		"func (s *UserFeedServerStream) Recv() (*LoginReq, error)",
+		"func (c *UserClient) Feed(ctx context.Context) (*UserFeedClientStream, error)",
+		"return srv.Feed(&UserFeedServerStream{Stream: st})",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("generated code misses %q", want)
+		}
+	}
+}
+
+func writePackage(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "sagittarius-gen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for name, src := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestParseGo(t *testing.T) {
+	dir := writePackage(t, map[string]string{
+		"user.go": `package user
+
+import "github.com/ousanki/sagittarius/server/tcp"
+
+const LoginID = 1001
+
+type Req struct{}
+type Resp struct{}
+
+func login(c *tcp.Context, req *Req) (*Resp, error) { return nil, nil }
+
+func Register(g *tcp.Group) {
+	g.Handle(LoginID, login)
+	g.HandleNamed("user.login", login)
+	g.Handle(LoginID, login)
+}
+`,
+		"user.gen.go": `// Code generated by sagittarius-gen. DO NOT EDIT.
+
+package user
+
+import "github.com/ousanki/sagittarius/server/tcp"
+
+type server interface{ Login(*tcp.Context, *Req) (*Resp, error) }
+
+func registerGen(g *tcp.Group, srv server) {
+	g.Handle(1001, srv.Login)
+}
+`,
+	})
+	svc, err := parseGo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, mt := range svc.Methods {
+		names = append(names, mt.Name)
+	}
+	if len(names) != 2 || names[0] == names[1] {
+		t.Fatalf("want 2 distinct methods, got %v", names)
+	}
+	if _, err = generate(svc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseGoMethodHandler(t *testing.T) {
+	dir := writePackage(t, map[string]string{
+		"user.go": `package user
+
+import "github.com/ousanki/sagittarius/server/tcp"
+
+type Req struct{}
+type Resp struct{}
+type Service struct{}
+
+func (s *Service) Login(c *tcp.Context, req *Req) (*Resp, error) { return nil, nil }
+
+func Register(g *tcp.Group, s *Service) {
+	g.Handle(1001, s.Login)
+}
+`,
+	})
+	_, err := parseGo(dir)
+	if err == nil || !strings.Contains(err.Error(), "s.Login") {
+		t.Fatalf("want error for method handler, got %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ousanki/sagittarius/server/tcp"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 注册函数对应的流模式
+var _registers = map[string]string{
+	"Handle":       "",
+	"HandleNamed":  "",
+	"ServerStream": "server",
+	"ClientStream": "client",
+	"BidiStream":   "bidi",
+}
+
+// parseGo 扫描包内 Group.Handle / HandleNamed / *Stream 注册的强类型处理函数,
+// 处理函数须为包级函数, 方法值及函数字面量返回错误; 已生成的文件只读取其中的常量
+func parseGo(dir string) (*Service, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("%s: expect exactly one package, got %d", dir, len(pkgs))
+	}
+
+	var svc Service
+	consts := make(map[string]int64)
+	funcs := make(map[string]*ast.FuncType)
+	var calls []*ast.CallExpr
+	for name, pkg := range pkgs {
+		svc.Package = name
+		for _, f := range pkg.Files {
+			generated := isGenerated(f)
+			ast.Inspect(f, func(n ast.Node) bool {
+				switch x := n.(type) {
+				case *ast.ValueSpec:
+					for i, id := range x.Names {
+						if i < len(x.Values) {
+							if v, ok := intLit(x.Values[i]); ok {
+								consts[id.Name] = v
+							}
+						}
+					}
+				case *ast.FuncDecl:
+					if x.Recv == nil {
+						funcs[x.Name.Name] = x.Type
+					}
+				case *ast.CallExpr:
+					if sel, ok := x.Fun.(*ast.SelectorExpr); ok && len(x.Args) == 2 && !generated {
+						if _, ok := _registers[sel.Sel.Name]; ok {
+							calls = append(calls, x)
+						}
+					}
+				}
+				return true
+			})
+		}
+	}
+
+	for _, call := range calls {
+		register := call.Fun.(*ast.SelectorExpr).Sel.Name
+		fn, ok := call.Args[1].(*ast.Ident)
+		if !ok {
+			return nil, fmt.Errorf("%s: handler %s is not a package-level function", fset.Position(call.Args[1].Pos()), types.ExprString(call.Args[1]))
+		}
+		if funcs[fn.Name] == nil {
+			return nil, fmt.Errorf("%s: handler %s is not a package-level function", fset.Position(fn.Pos()), fn.Name)
+		}
+		mt := Method{
+			Name:   exported(fn.Name),
+			Stream: _registers[register],
+		}
+		switch arg := call.Args[0].(type) {
+		case *ast.BasicLit:
+			if arg.Kind == token.STRING {
+				mt.Named, _ = strconv.Unquote(arg.Value)
+				mt.ID = tcp.MethodID(mt.Named)
+			} else if v, ok := intLit(arg); ok {
+				mt.ID = v
+			} else {
+				continue
+			}
+		case *ast.Ident:
+			v, ok := consts[arg.Name]
+			if !ok {
+				return nil, fmt.Errorf("%s: route id %s is not an integer constant", fset.Position(arg.Pos()), arg.Name)
+			}
+			mt.ID = v
+		default:
+			return nil, fmt.Errorf("%s: unsupported route id expression", fset.Position(arg.Pos()))
+		}
+		if mt.Stream == "" {
+			ft := funcs[fn.Name]
+			if ft.Params.NumFields() != 2 || ft.Results.NumFields() != 2 {
+				return nil, fmt.Errorf("%s: %s is not func(*tcp.Context, Req) (Resp, error)", fset.Position(fn.Pos()), fn.Name)
+			}
+			mt.Request = types.ExprString(fieldType(ft.Params, 1))
+			mt.Response = types.ExprString(fieldType(ft.Results, 0))
+		}
+		svc.Methods = append(svc.Methods, mt)
+	}
+	sort.Slice(svc.Methods, func(i, j int) bool {
+		return svc.Methods[i].ID < svc.Methods[j].ID
+	})
+	svc.Methods = dedupe(svc.Methods)
+	return &svc, nil
+}
+
+// dedupe 同一路由只保留一个; 同一函数注册为多个路由时, 后出现的名称追加序号
+func dedupe(methods []Method) []Method {
+	out := methods[:0]
+	ids := make(map[int64]struct{}, len(methods))
+	names := make(map[string]struct{}, len(methods))
+	for _, mt := range methods {
+		if _, ok := ids[mt.ID]; ok {
+			continue
+		}
+		ids[mt.ID] = struct{}{}
+		name := mt.Name
+		for i := 2; ; i++ {
+			if _, ok := names[name]; !ok {
+				break
+			}
+			name = mt.Name + strconv.Itoa(i)
+		}
+		mt.Name = name
+		names[name] = struct{}{}
+		out = append(out, mt)
+	}
+	return out
+}
+
+// isGenerated 文件首部带有 "Code generated ... DO NOT EDIT." 注释
+func isGenerated(f *ast.File) bool {
+	for _, cg := range f.Comments {
+		if cg.Pos() > f.Package {
+			break
+		}
+		for _, c := range cg.List {
+			if strings.HasPrefix(c.Text, "// Code generated ") && strings.HasSuffix(c.Text, " DO NOT EDIT.") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func intLit(e ast.Expr) (int64, bool) {
+	lit, ok := e.(*ast.BasicLit)
+	if !ok || lit.Kind != token.INT {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(lit.Value, 0, 64)
+	return v, err == nil
+}
+
+// fieldType 按位置取参数类型, 兼容 (a, b T) 的写法
+func fieldType(fl *ast.FieldList, index int) ast.Expr {
+	var i int
+	for _, f := range fl.List {
+		n := len(f.Names)
+		if n == 0 {
+			n = 1
+		}
+		if index < i+n {
+			return f.Type
+		}
+		i += n
+	}
+	return nil
+}
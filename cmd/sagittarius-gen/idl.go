@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/ousanki/sagittarius/server/tcp"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// IDL 示例:
+//
+//	package user
+//
+//	message LoginReq {
+//	    uid   int64
+//	    token string
+//	}
+//
+//	service User {
+//	    rpc Login(LoginReq) LoginResp = 1001
+//	    rpc Profile(ProfileReq) ProfileResp = "user.profile"
+//	    stream Feed(FeedReq) FeedMsg = 1003 server
+//	}
+var (
+	_packageRe = regexp.MustCompile(`^package\s+(\w+)$`)
+	_messageRe = regexp.MustCompile(`^message\s+(\w+)\s*\{$`)
+	_serviceRe = regexp.MustCompile(`^service\s+(\w+)\s*\{$`)
+	_fieldRe   = regexp.MustCompile(`^(\w+)\s+(\S+)$`)
+	_methodRe  = regexp.MustCompile(`^(rpc|stream)\s+(\w+)\s*\(\s*(\w+)\s*\)\s*(\w+)\s*=\s*(\d+|"[^"]+")(?:\s+(server|client|bidi))?$`)
+)
+
+func parseIDL(r io.Reader) (*Service, error) {
+	svc := new(Service)
+	var (
+		msg     *Message
+		inSvc   bool
+		lineNum int
+	)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		lineNum++
+		line := sc.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		switch {
+		case line == "}":
+			if msg == nil && !inSvc {
+				return nil, fmt.Errorf("line %d: unexpected '}'", lineNum)
+			}
+			if msg != nil {
+				svc.Messages = append(svc.Messages, *msg)
+			}
+			msg, inSvc = nil, false
+		case msg != nil:
+			m := _fieldRe.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("line %d: bad field %q", lineNum, line)
+			}
+			msg.Fields = append(msg.Fields, Field{Name: exported(m[1]), Type: m[2], Tag: m[1]})
+		case inSvc:
+			m := _methodRe.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("line %d: bad method %q", lineNum, line)
+			}
+			mt := Method{
+				Name:     m[2],
+				Request:  "*" + m[3],
+				Response: "*" + m[4],
+			}
+			if m[1] == "stream" {
+				mt.Stream = m[6]
+				if mt.Stream == "" {
+					mt.Stream = "bidi"
+				}
+			} else if m[6] != "" {
+				return nil, fmt.Errorf("line %d: stream mode on rpc %s", lineNum, mt.Name)
+			}
+			if strings.HasPrefix(m[5], `"`) {
+				mt.Named = strings.Trim(m[5], `"`)
+				mt.ID = tcp.MethodID(mt.Named)
+			} else {
+				id, err := strconv.ParseInt(m[5], 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: bad route id: %v", lineNum, err)
+				}
+				mt.ID = id
+			}
+			svc.Methods = append(svc.Methods, mt)
+		case _packageRe.MatchString(line):
+			svc.Package = _packageRe.FindStringSubmatch(line)[1]
+		case _messageRe.MatchString(line):
+			msg = &Message{Name: _messageRe.FindStringSubmatch(line)[1]}
+		case _serviceRe.MatchString(line):
+			if svc.Name != "" {
+				return nil, fmt.Errorf("line %d: only one service per file", lineNum)
+			}
+			svc.Name = _serviceRe.FindStringSubmatch(line)[1]
+			inSvc = true
+		default:
+			return nil, fmt.Errorf("line %d: unexpected %q", lineNum, line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if msg != nil || inSvc {
+		return nil, fmt.Errorf("line %d: missing '}'", lineNum)
+	}
+	return svc, nil
+}
@@ -0,0 +1,84 @@
+// sagittarius-gen 根据路由定义生成强类型客户端及服务端接口
+//
+//	sagittarius-gen -idl user.sgt -out user.gen.go
+//	sagittarius-gen -go ./user -service User -out ./user/user_client.gen.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	var (
+		idl     = flag.String("idl", "", "IDL file")
+		goDir   = flag.String("go", "", "package dir with typed handlers")
+		service = flag.String("service", "", "service name, default package name")
+		pkg     = flag.String("package", "", "output package name, default from source")
+		out     = flag.String("out", "", "output file, default stdout")
+	)
+	flag.Parse()
+
+	svc, err := load(*idl, *goDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "sagittarius-gen:", err)
+		os.Exit(1)
+	}
+	if *service != "" {
+		svc.Name = *service
+	}
+	if svc.Name == "" {
+		svc.Name = exported(svc.Package)
+	}
+	if *pkg != "" {
+		svc.Package = *pkg
+	}
+	if svc.Package == "" {
+		fmt.Fprintln(os.Stderr, "sagittarius-gen: missing package name")
+		os.Exit(1)
+	}
+
+	src, err := generate(svc)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "sagittarius-gen:", err)
+		os.Exit(1)
+	}
+	if *out == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	if err = ioutil.WriteFile(*out, src, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, "sagittarius-gen:", err)
+		os.Exit(1)
+	}
+}
+
+func load(idl, goDir string) (*Service, error) {
+	switch {
+	case idl != "" && goDir != "":
+		return nil, fmt.Errorf("-idl and -go are exclusive")
+	case idl != "":
+		f, err := os.Open(idl)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		svc, err := parseIDL(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", idl, err)
+		}
+		svc.Source = filepath.Base(idl)
+		return svc, nil
+	case goDir != "":
+		svc, err := parseGo(goDir)
+		if err != nil {
+			return nil, err
+		}
+		svc.Source = filepath.Base(goDir)
+		return svc, nil
+	}
+	return nil, fmt.Errorf("one of -idl or -go is required")
+}
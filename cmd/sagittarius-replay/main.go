@@ -0,0 +1,65 @@
+// sagittarius-replay 将 record 录制的请求回放至 Engine 并对比回包
+//
+//	sagittarius-replay -addr 127.0.0.1:8080 -speed 10 ./record/traffic.log
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/ousanki/sagittarius/server/tcp"
+	"github.com/ousanki/sagittarius/server/tcp/record"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	var (
+		addr    = flag.String("addr", "127.0.0.1:8080", "engine address")
+		speed   = flag.Float64("speed", 1, "replay speed relative to recording, 0 for no pause")
+		timeout = flag.Duration("timeout", 3*time.Second, "wait for each reply")
+		ignore  = flag.String("ignore", "", "comma separated header keys ignored when comparing")
+	)
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: sagittarius-replay [flags] file...")
+		os.Exit(2)
+	}
+
+	cl, err := tcp.Dial(*addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "sagittarius-replay:", err)
+		os.Exit(1)
+	}
+	defer cl.Close()
+
+	opts := []record.ReplayOption{
+		record.SetReplaySpeed(*speed),
+		record.SetReplayTimeout(*timeout),
+	}
+	if *ignore != "" {
+		opts = append(opts, record.SetReplayIgnoreHeaders(strings.Split(*ignore, ",")...))
+	}
+	rp := record.NewReplayer(cl, opts...)
+
+	ok := true
+	for _, file := range flag.Args() {
+		f, err := os.Open(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "sagittarius-replay:", err)
+			os.Exit(1)
+		}
+		report, err := rp.Replay(context.Background(), f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "sagittarius-replay: %s: %v\n", file, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s: %s", file, report)
+		ok = ok && report.OK()
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
@@ -89,4 +89,48 @@ func BuildCode(code int, message string) code {
 
 func ErrorIs(c code, err error) bool {
 	return Cause(c).Error() == Cause(err).Error()
-}
\ No newline at end of file
+}
+
+// FromError 沿 Cause 链查找 *Error
+func FromError(err error) (*Error, bool) {
+	type causer interface {
+		Cause() error
+	}
+
+	for err != nil {
+		if e, ok := err.(*Error); ok {
+			return e, true
+		}
+		cause, ok := err.(causer)
+		if !ok {
+			break
+		}
+		err = cause.Cause()
+	}
+	return nil, false
+}
+
+// 可重试的错误码
+var _retryable = make(map[int]struct{})
+var _retryableMu sync.RWMutex
+
+// MarkRetryable 标记错误码为暂时性错误, 客户端重试策略据此重试
+func MarkRetryable(codes ...int) {
+	_retryableMu.Lock()
+	defer _retryableMu.Unlock()
+	for _, c := range codes {
+		_retryable[c] = struct{}{}
+	}
+}
+
+// IsRetryable err 的 *Error 是否被标记为可重试
+func IsRetryable(err error) bool {
+	e, ok := FromError(err)
+	if !ok {
+		return false
+	}
+	_retryableMu.RLock()
+	defer _retryableMu.RUnlock()
+	_, ok = _retryable[e.Code]
+	return ok
+}
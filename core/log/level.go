@@ -2,6 +2,7 @@ package log
 
 import (
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -47,6 +48,23 @@ func (l Level) StringLower() string {
 	}
 }
 
+// ParseLevel 不区分大小写, 空串及 "none" 为 NoneLevel
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "", "none":
+		return NoneLevel, nil
+	case "debug":
+		return DebugLevel, nil
+	case "info":
+		return InfoLevel, nil
+	case "warn":
+		return WarnLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	}
+	return NoneLevel, fmt.Errorf("log: unknown level %q", s)
+}
+
 func (l Level) isNoneLevel() bool {
 	return l == NoneLevel
 }
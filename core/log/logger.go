@@ -4,11 +4,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	rotate "github.com/lestrrat-go/file-rotatelogs"
 	"os"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -31,10 +34,23 @@ type Logger struct {
 	// 基本参数
 	writer Writer
 	once   sync.Once
+	// 运行时调整的最低输出级别
+	min int32
 }
 
+// 按名称登记的日志器, 供运行时调整级别
+var (
+	_loggersMu sync.RWMutex
+	_loggers   = make(map[string]*Logger)
+)
+
+var (
+	ErrLoggerName      = errors.New("log: logger name is empty")
+	ErrLoggerDuplicate = errors.New("log: logger name already registered")
+)
+
 func New(name string) *Logger {
-	return &Logger{
+	l := &Logger{
 		name:             name,
 		path:             _defaultPath,
 		rotation:         RotationDay,
@@ -45,7 +61,43 @@ func New(name string) *Logger {
 		EncodeTime:       defaultTimeEncoder,
 		EncoderLevel:     defaultLevelEncoder,
 		consoleSeparator: " ",
+		min:              int32(NoneLevel),
+	}
+	return l
+}
+
+// Register 登记常驻的日志器, 供 Lookup 按名称查找; 名称为空或已登记时返回错误, 不覆盖已登记的日志器
+func Register(l *Logger) error {
+	if l.name == "" {
+		return ErrLoggerName
+	}
+	_loggersMu.Lock()
+	defer _loggersMu.Unlock()
+	if _, ok := _loggers[l.name]; ok {
+		return ErrLoggerDuplicate
+	}
+	_loggers[l.name] = l
+	return nil
+}
+
+// Lookup 按名称查找 Register 登记的日志器
+func Lookup(name string) (*Logger, bool) {
+	_loggersMu.RLock()
+	defer _loggersMu.RUnlock()
+	l, ok := _loggers[name]
+	return l, ok
+}
+
+// Names Register 登记的日志器名称
+func Names() []string {
+	_loggersMu.RLock()
+	defer _loggersMu.RUnlock()
+	names := make([]string, 0, len(_loggers))
+	for name := range _loggers {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
 }
 
 func NewGroup(level Level) *Logger {
@@ -59,7 +111,30 @@ func NewGroup(level Level) *Logger {
 		EncodeTime:       defaultTimeEncoder,
 		EncoderLevel:     defaultLevelEncoder,
 		consoleSeparator: " ",
+		min:              int32(NoneLevel),
+	}
+}
+
+// SetLevel 运行时调整最低输出级别, 低于该级别的日志被丢弃, NoneLevel 为不过滤;
+// 未设置级别的日志器 Write 的日志视为 InfoLevel
+func (l *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(&l.min, int32(level))
+}
+
+// Level 当前最低输出级别
+func (l *Logger) Level() Level {
+	return Level(atomic.LoadInt32(&l.min))
+}
+
+func (l *Logger) enabled(level Level) bool {
+	min := l.Level()
+	if min == NoneLevel {
+		return true
 	}
+	if level == NoneLevel {
+		level = InfoLevel
+	}
+	return !level.less(min)
 }
 
 func (l *Logger) fullName() string {
@@ -171,6 +246,9 @@ func (l *Logger) Fatal(ctx context.Context, format string, args ...interface{})
 }
 
 func (l *Logger) write(ctx context.Context, level Level, format string, args ...interface{}) {
+	if !l.enabled(level) {
+		return
+	}
 	l.build()
 	w := l.writer.check(level)
 
@@ -0,0 +1,56 @@
+package metric
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Collector 每个周期采集一次的指标, 返回值需可 JSON 编码
+type Collector func() interface{}
+
+var (
+	_collectorsMu sync.RWMutex
+	_collectors   = make(map[string]Collector)
+)
+
+// Register 注册指标, 同名覆盖
+func Register(name string, fn Collector) {
+	_collectorsMu.Lock()
+	defer _collectorsMu.Unlock()
+	_collectors[name] = fn
+}
+
+func Unregister(name string) {
+	_collectorsMu.Lock()
+	defer _collectorsMu.Unlock()
+	delete(_collectors, name)
+}
+
+// Collect 采集所有已注册指标的当前值
+func Collect() map[string]interface{} {
+	_collectorsMu.RLock()
+	defer _collectorsMu.RUnlock()
+	if len(_collectors) == 0 {
+		return nil
+	}
+	m := make(map[string]interface{}, len(_collectors))
+	for name, fn := range _collectors {
+		m[name] = fn()
+	}
+	return m
+}
+
+func formatCollected(m map[string]interface{}) string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s: %+v\n", name, m[name])
+	}
+	return b.String()
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/ousanki/sagittarius/core/log"
 	"runtime"
+	"sync"
 	"time"
 )
 
@@ -52,9 +53,11 @@ type MemData struct {
 	NumGoroutine string
 }
 
+// ReportData 以 JSON 编码时周期数据为 cycle, 当前数据为 current, 注册的指标为 collected
 type ReportData struct {
-	MemCycleData
-	MemData
+	MemCycleData `json:"cycle"`
+	MemData      `json:"current"`
+	Collected    map[string]interface{} `json:"collected,omitempty"`
 }
 
 func (r *ReportData) Format() string {
@@ -81,6 +84,10 @@ func (r *ReportData) Format() string {
 	buf.WriteString("|---------------|---------------|---------------|\n")
 	buf.WriteString(fmt.Sprintf("| GCCPUFraction |%15s|               |\n", r.MemData.GCCPUFraction))
 	buf.WriteString("|---------------|---------------|---------------|\n\n")
+	if len(r.Collected) > 0 {
+		buf.WriteString(formatCollected(r.Collected))
+		buf.WriteString("\n")
+	}
 	return buf.String()
 }
 
@@ -98,6 +105,7 @@ func (mm *MemMetric) GetReport() ReportData {
 	return ReportData{
 		MemCycleData: mm.getMemCycle(),
 		MemData:      mm.getMem(),
+		Collected:    Collect(),
 	}
 }
 
@@ -167,6 +175,12 @@ func size2Human(size uint64) string {
 var _m *MemMetric
 var stackLogger *log.Logger
 
+// 最近一次周期的报告
+var (
+	_latestMu sync.RWMutex
+	_latest   *ReportData
+)
+
 func init() {
 	_m = new(MemMetric)
 	stackLogger = log.New("stack")
@@ -175,6 +189,8 @@ func init() {
 		log.SetPath("./log"),
 		log.SetFormat(log.ConsoleFormat),
 	)
+	// 登记后可由管理路由按名称调整级别
+	log.Register(stackLogger)
 }
 
 func loadStats() {
@@ -190,9 +206,26 @@ func Metric() {
 			loadStats()
 			// 生成报告
 			report := _m.GetReport()
+			_latestMu.Lock()
+			_latest = &report
+			_latestMu.Unlock()
 			stackLogger.Writeln(report.Format())
 			// 后处理
 			_m.before = _m.current
 		}
 	}()
 }
+
+// Latest 最近一次周期的报告, Metric 未运行时返回当前内存状态, 周期数据为空
+func Latest() ReportData {
+	_latestMu.RLock()
+	latest := _latest
+	_latestMu.RUnlock()
+	if latest != nil {
+		return *latest
+	}
+	var mm MemMetric
+	runtime.ReadMemStats(&mm.current.MemStats)
+	mm.current.NumGoroutine = runtime.NumGoroutine()
+	return ReportData{MemData: mm.getMem(), Collected: Collect()}
+}
@@ -0,0 +1,319 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ousanki/sagittarius/core/code"
+	"github.com/ousanki/sagittarius/core/log"
+	"github.com/ousanki/sagittarius/metric"
+	"net"
+	"runtime/pprof"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// 管理路由 ID, SetAdmin 开启后由 Engine 内置处理, 与 AuthID、PingID 一样不可由 Group 注册
+const (
+	// AdminConnsID 活跃连接, 回包 []ConnInfo
+	AdminConnsID int64 = -100
+	// AdminRoutesID 路由表, 回包 []RouteInfo
+	AdminRoutesID int64 = -101
+	// AdminMetricID 最近一次的 metric.ReportData
+	AdminMetricID int64 = -102
+	// AdminLogID 请求 AdminLogRequest, 回包 []LoggerInfo
+	AdminLogID int64 = -103
+	// AdminPprofID 请求 AdminPprofRequest, 回包 AdminPprofResponse
+	AdminPprofID int64 = -104
+)
+
+const (
+	// cpu profile 的默认及最长采样时长
+	_cpuProfileSeconds    = 10
+	_cpuProfileMaxSeconds = 60
+)
+
+// ErrForbidden SetAdmin 的校验函数拒绝了管理请求
+var ErrForbidden = code.BuildCode(-4, "forbidden")
+
+// _errAdminAsync 管理路由已转入后台, 由后台 goroutine 回包
+var _errAdminAsync = errors.New("tcp: admin reply is async")
+
+// reservedID 内置路由 ID, 不可通过 Group 注册或替换
+func reservedID(id int64) bool {
+	return id == AuthID || id == PingID || (id <= AdminConnsID && id >= AdminPprofID)
+}
+
+// ConnInfo 活跃连接
+type ConnInfo struct {
+	Remote   string `json:"remote"`
+	Accepted string `json:"accepted"`
+	Age      string `json:"age"`
+	BytesIn  uint64 `json:"bytes_in"`
+	BytesOut uint64 `json:"bytes_out"`
+	Frames   uint64 `json:"frames"`
+	Streams  int    `json:"streams"`
+	// 出站队列中的帧数
+	Queued int `json:"queued"`
+}
+
+// AdminLogRequest Name 为空时只列出日志器, Level 见 log.ParseLevel
+type AdminLogRequest struct {
+	Name  string `json:"name"`
+	Level string `json:"level"`
+}
+
+type LoggerInfo struct {
+	Name  string `json:"name"`
+	Level string `json:"level"`
+}
+
+// AdminPprofRequest Profile 为 cpu 时采样 Seconds 秒, 其余为 pprof.Lookup 的名称
+type AdminPprofRequest struct {
+	Profile string `json:"profile"`
+	Seconds int    `json:"seconds"`
+	Debug   int    `json:"debug"`
+}
+
+// AdminPprofResponse Data 为 pprof 格式, Debug 大于 0 时为文本
+type AdminPprofResponse struct {
+	Profile string `json:"profile"`
+	Data    []byte `json:"data"`
+}
+
+// statConn 统计连接流量
+type statConn struct {
+	in  uint64
+	out uint64
+	net.Conn
+}
+
+func (sc *statConn) Read(p []byte) (int, error) {
+	n, err := sc.Conn.Read(p)
+	atomic.AddUint64(&sc.in, uint64(n))
+	return n, err
+}
+
+func (sc *statConn) Write(p []byte) (int, error) {
+	n, err := sc.Conn.Write(p)
+	atomic.AddUint64(&sc.out, uint64(n))
+	return n, err
+}
+
+func (c *conn) info(now time.Time) ConnInfo {
+	ci := ConnInfo{
+		Remote:   c.remoteAddr,
+		Accepted: c.accepted.Format("2006-01-02 15:04:05.000"),
+		Age:      now.Sub(c.accepted).Truncate(time.Second).String(),
+		BytesIn:  atomic.LoadUint64(&c.stat.in),
+		BytesOut: atomic.LoadUint64(&c.stat.out),
+		Frames:   atomic.LoadUint64(&c.frames),
+	}
+	c.smu.Lock()
+	ci.Streams = len(c.streams)
+	c.smu.Unlock()
+	if c.out != nil {
+		ci.Queued = c.out.Len()
+	}
+	return ci
+}
+
+// Conns 活跃连接, 按建立时间排序
+func (s *Engine) Conns() []ConnInfo {
+	now := time.Now()
+	s.mu.Lock()
+	conns := make([]*conn, 0, len(s.activeConn))
+	for c := range s.activeConn {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].accepted.Before(conns[j].accepted)
+	})
+	infos := make([]ConnInfo, 0, len(conns))
+	for _, c := range conns {
+		infos = append(infos, c.info(now))
+	}
+	return infos
+}
+
+func (s *Engine) adminRoutes() map[int64]*route {
+	return map[int64]*route{
+		AdminConnsID:  s.adminRoute(AdminConnsID, "admin.conns", s.adminConns),
+		AdminRoutesID: s.adminRoute(AdminRoutesID, "admin.routes", s.adminRouteTable),
+		AdminMetricID: s.adminRoute(AdminMetricID, "admin.metric", s.adminMetric),
+		AdminLogID:    s.adminRoute(AdminLogID, "admin.log", s.adminLog),
+		AdminPprofID:  s.adminRoute(AdminPprofID, "admin.pprof", s.adminPprof),
+	}
+}
+
+// adminRoute 只处理 SetAdmin 校验通过的请求
+func (s *Engine) adminRoute(id int64, name string, fn func(c *Context) (interface{}, error)) *route {
+	return &route{
+		id:   id,
+		name: name,
+		cores: []core{func(c *Context) {
+			if s.adminAllow == nil || !s.adminAllow(c) {
+				genLogger.Write(c.Ctx(), "tcp admin route:%d forbidden, remote:%v", id, c.Ctx().Value("remote"))
+				c.WriteError(id, ErrForbidden)
+				return
+			}
+			resp, err := fn(c)
+			if err == _errAdminAsync {
+				return
+			}
+			if err != nil {
+				c.WriteError(id, err)
+				return
+			}
+			c.Write(id, resp)
+		}},
+	}
+}
+
+// AdminLoopback 只允许本机连接 (含 unix socket、net.Pipe) 调用管理路由, 拒绝 http 网关及 WebSocket
+// (经本机反向代理时对端地址均为回环地址), 可作为 SetAdmin 的校验函数, 或与 Principal 的检查组合使用
+func AdminLoopback(c *Context) bool {
+	if c.reply != nil || c.conn == nil {
+		return false
+	}
+	nc := c.conn
+	if sc, ok := nc.(*statConn); ok {
+		nc = sc.Conn
+	}
+	if _, ok := nc.(*wsConn); ok {
+		return false
+	}
+	host, _, err := net.SplitHostPort(c.conn.RemoteAddr().String())
+	if err != nil {
+		// unix socket 及 net.Pipe
+		return c.conn.RemoteAddr().Network() != "tcp" && c.conn.RemoteAddr().Network() != "udp"
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// adminError 管理请求参数错误, 错误码同 ErrBadRequest
+func adminError(format string, args ...interface{}) error {
+	return code.BuildCode(ErrBadRequest.(*code.Error).Code, fmt.Sprintf(format, args...))
+}
+
+// readAdmin 空 body 视为零值请求
+func readAdmin(c *Context, v interface{}) error {
+	if len(c.Body()) == 0 || bytes.Equal(c.Body(), []byte("null")) {
+		return nil
+	}
+	if err := json.Unmarshal(c.Body(), v); err != nil {
+		return adminError("bad request: %v", err)
+	}
+	return nil
+}
+
+func (s *Engine) adminConns(c *Context) (interface{}, error) {
+	return s.Conns(), nil
+}
+
+func (s *Engine) adminRouteTable(c *Context) (interface{}, error) {
+	return s.Routes(), nil
+}
+
+func (s *Engine) adminMetric(c *Context) (interface{}, error) {
+	return metric.Latest(), nil
+}
+
+func (s *Engine) adminLog(c *Context) (interface{}, error) {
+	var req AdminLogRequest
+	if err := readAdmin(c, &req); err != nil {
+		return nil, err
+	}
+	if req.Name != "" {
+		l, ok := log.Lookup(req.Name)
+		if !ok {
+			return nil, adminError("unknown logger %q", req.Name)
+		}
+		level, err := log.ParseLevel(req.Level)
+		if err != nil {
+			return nil, adminError("%v", err)
+		}
+		l.SetLevel(level)
+		genLogger.Write(c.Ctx(), "tcp admin set log level, logger:%s, level:%s, remote:%v", req.Name, req.Level, c.Ctx().Value("remote"))
+	}
+	names := log.Names()
+	infos := make([]LoggerInfo, 0, len(names))
+	for _, name := range names {
+		l, _ := log.Lookup(name)
+		level := "none"
+		if lv := l.Level(); lv != log.NoneLevel {
+			level = lv.StringLower()
+		}
+		infos = append(infos, LoggerInfo{Name: name, Level: level})
+	}
+	return infos, nil
+}
+
+func (s *Engine) adminPprof(c *Context) (interface{}, error) {
+	var req AdminPprofRequest
+	if err := readAdmin(c, &req); err != nil {
+		return nil, err
+	}
+	if req.Profile == "cpu" {
+		sess := c.Session()
+		if sess == nil {
+			return cpuProfile(c.Ctx(), req.Seconds)
+		}
+		// 采样期间不阻塞连接的读循环, 完成后经 Session 回包; 连接断开时提前结束
+		id := c.ID()
+		values := c.replyValues()
+		go func() {
+			resp, err := cpuProfile(sess.Ctx(), req.Seconds)
+			if err != nil {
+				e, ok := code.FromError(err)
+				if !ok {
+					e = ErrInternal.(*code.Error)
+				}
+				values[HeaderCode] = e.Code
+				values[HeaderMessage] = e.Message
+				resp = nil
+			}
+			if err = sess.Write(id, values, resp); err != nil {
+				genLogger.Write(sess.Ctx(), "tcp admin route:%d cpu profile reply error, err:%v", id, err)
+			}
+		}()
+		return nil, _errAdminAsync
+	}
+	var buf bytes.Buffer
+	p := pprof.Lookup(req.Profile)
+	if p == nil {
+		return nil, adminError("unknown profile %q", req.Profile)
+	}
+	if err := p.WriteTo(&buf, req.Debug); err != nil {
+		return nil, err
+	}
+	return AdminPprofResponse{Profile: req.Profile, Data: buf.Bytes()}, nil
+}
+
+// cpuProfile 采样 seconds 秒, ctx 结束时提前停止
+func cpuProfile(ctx context.Context, seconds int) (interface{}, error) {
+	if seconds <= 0 {
+		seconds = _cpuProfileSeconds
+	}
+	if seconds > _cpuProfileMaxSeconds {
+		seconds = _cpuProfileMaxSeconds
+	}
+	var buf bytes.Buffer
+	if err := pprof.StartCPUProfile(&buf); err != nil {
+		return nil, adminError("%v", err)
+	}
+	t := time.NewTimer(time.Duration(seconds) * time.Second)
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+		t.Stop()
+	}
+	pprof.StopCPUProfile()
+	return AdminPprofResponse{Profile: "cpu", Data: buf.Bytes()}, nil
+}
@@ -0,0 +1,83 @@
+package tcp_test
+
+import (
+	"github.com/ousanki/sagittarius/server/tcp"
+	"github.com/ousanki/sagittarius/server/tcp/tcptest"
+	"testing"
+	"time"
+)
+
+func TestAdminAllow(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		allow func(*tcp.Context) bool
+		code  int
+	}{
+		{"disabled", nil, -6},
+		{"loopback", tcp.AdminLoopback, 0},
+		{"principal", func(c *tcp.Context) bool { return c.Principal() != nil }, -4},
+	} {
+		e := tcp.NewApp("tcp")
+		e.WithOptions(tcp.SetAdmin(tc.allow))
+		e.HandleNamed("svc.Add", add)
+		srv := tcptest.NewServer(e)
+
+		var routes []tcp.RouteInfo
+		err := srv.Call(tcp.AdminRoutesID, nil, &routes)
+		if tc.code != 0 {
+			tcptest.AssertCode(t, err, tc.code)
+		} else if err != nil || len(routes) != 1 {
+			t.Fatalf("%s: want route table, got %v %v", tc.name, routes, err)
+		}
+		srv.Close()
+	}
+}
+
+func TestReservedID(t *testing.T) {
+	e := tcp.NewApp("tcp")
+	for _, id := range []int64{tcp.AuthID, tcp.PingID, tcp.AdminConnsID, tcp.AdminPprofID} {
+		for name, register := range map[string]func(){
+			"Invoke":  func() { e.Invoke(id, func(c *tcp.Context) {}) },
+			"Handle":  func() { e.Handle(id, add) },
+			"Replace": func() { e.Replace(id, func(c *tcp.Context) {}) },
+		} {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Fatalf("%s id %d: want panic for reserved id", name, id)
+					}
+				}()
+				register()
+			}()
+		}
+	}
+}
+
+func TestAdminCPUProfile(t *testing.T) {
+	e := tcp.NewApp("tcp")
+	e.WithOptions(tcp.SetAdmin(tcp.AdminLoopback))
+	id := e.HandleNamed("svc.Add", add)
+	srv := tcptest.NewServer(e)
+	defer srv.Close()
+
+	done := make(chan error, 1)
+	var resp tcp.AdminPprofResponse
+	go func() {
+		done <- srv.Call(tcp.AdminPprofID, tcp.AdminPprofRequest{Profile: "cpu", Seconds: 1}, &resp)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	// 采样期间同一连接上的请求不被阻塞
+	start := time.Now()
+	if err := srv.Call(id, addReq{A: 1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("request blocked by cpu profile for %v", d)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if resp.Profile != "cpu" || len(resp.Data) == 0 {
+		t.Fatalf("want cpu profile, got %q with %d bytes", resp.Profile, len(resp.Data))
+	}
+}
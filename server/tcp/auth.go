@@ -0,0 +1,88 @@
+package tcp
+
+import (
+	"context"
+	"github.com/ousanki/sagittarius/core/code"
+	"time"
+)
+
+// AuthID 鉴权帧的路由 ID, 启用鉴权时连接的首个帧必须为鉴权帧
+const AuthID int64 = -1
+
+// DefaultAuthTimeout 连接建立后完成鉴权的期限
+const DefaultAuthTimeout = 5 * time.Second
+
+// ErrUnauthenticated 未鉴权的请求或鉴权失败
+var ErrUnauthenticated = code.BuildCode(-3, "unauthenticated")
+
+// Authenticator 校验鉴权帧的 header values 及 body, 返回的 principal 保存在会话上;
+// 返回 code.Error 时原样回包, 其余错误回包 ErrUnauthenticated
+type Authenticator interface {
+	Authenticate(ctx context.Context, header map[string]interface{}, body []byte) (interface{}, error)
+}
+
+type AuthenticatorFunc func(ctx context.Context, header map[string]interface{}, body []byte) (interface{}, error)
+
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, header map[string]interface{}, body []byte) (interface{}, error) {
+	return f(ctx, header, body)
+}
+
+// Principal 连接鉴权得到的身份, 未启用鉴权时为 nil
+func (c *Context) Principal() interface{} {
+	return c.principal
+}
+
+// Principal 连接鉴权得到的身份
+func (s *Session) Principal() interface{} {
+	return s.c.getPrincipal()
+}
+
+func (c *conn) getPrincipal() interface{} {
+	c.pmu.RLock()
+	defer c.pmu.RUnlock()
+	return c.principal
+}
+
+func (c *conn) setPrincipal(principal interface{}) {
+	c.pmu.Lock()
+	defer c.pmu.Unlock()
+	c.principal = principal
+}
+
+// authenticate 鉴权前只接受鉴权帧及心跳, 其余请求回包 ErrUnauthenticated; 鉴权失败返回 false, 断开连接
+func (c *conn) authenticate(ctx *Context) bool {
+	defer c.server.release(ctx)
+
+	id := ctx.header.GetID()
+	if id == PingID && !ctx.header.HasFlag(FlagStream) {
+		ctx.Write(PingID, nil)
+		return true
+	}
+	if ctx.header.HasFlag(FlagStream) || id != AuthID {
+		ctx.WriteError(id, ErrUnauthenticated)
+		return true
+	}
+	principal, err := c.server.auth.Authenticate(ctx.Ctx(), ctx.header.values, ctx.body.buf)
+	if err != nil {
+		genLogger.Write(c.ctx, "tcp conn auth error, remote:%s, err:%v", c.remoteAddr, err)
+		if _, ok := code.FromError(err); !ok {
+			err = ErrUnauthenticated
+		}
+		ctx.WriteError(AuthID, err)
+		return false
+	}
+	c.setPrincipal(principal)
+	c.authed = true
+	c.c.SetReadDeadline(time.Time{})
+	ctx.Write(AuthID, nil)
+	return true
+}
+
+// Authenticate 发送鉴权帧, 启用鉴权的 Engine 要求在其他请求之前完成
+func (cl *Client) Authenticate(ctx context.Context, header map[string]interface{}, req interface{}) error {
+	r, err := cl.Send(ctx, AuthID, header, req)
+	if err != nil {
+		return err
+	}
+	return r.Err()
+}
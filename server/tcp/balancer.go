@@ -0,0 +1,664 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ousanki/sagittarius/core/code"
+	"github.com/ousanki/sagittarius/server/tcp/registry"
+	"hash/fnv"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BalancePolicy 负载均衡策略
+type BalancePolicy int
+
+const (
+	// RoundRobin 按权重平滑轮询
+	RoundRobin BalancePolicy = iota
+	// LeastOutstanding 选择进行中请求数与权重之比最小的节点
+	LeastOutstanding
+	// ConsistentHash 按请求 header 中 hash key 的值一致性哈希, 缺少该值时轮询
+	ConsistentHash
+)
+
+func (p BalancePolicy) String() string {
+	switch p {
+	case RoundRobin:
+		return "round-robin"
+	case LeastOutstanding:
+		return "least-outstanding"
+	case ConsistentHash:
+		return "consistent-hash"
+	}
+	return "unknown"
+}
+
+const (
+	// 每个节点的连接数
+	DefaultPoolSize = 2
+	// 心跳间隔
+	DefaultHeartbeat = 5 * time.Second
+	// 幂等路由失败后换节点重试的次数
+	DefaultRetries = 1
+	_dialTimeout   = 3 * time.Second
+	// 每单位权重的虚拟节点数
+	_hashReplicas = 100
+	// 移除的节点等待进行中请求结束的时长
+	_removeTimeout = 5 * time.Second
+)
+
+var ErrNoNode = errors.New("tcp: no available node")
+
+// Ejection 统计窗口内请求数不少于 MinRequests 且失败率达到 ErrorRate 时摘除节点 Duration;
+// 连接失败、ErrInternal 及 ErrTimeout 计为失败, ErrorRate 为 0 时不按错误率摘除
+type Ejection struct {
+	Window      time.Duration
+	MinRequests int
+	ErrorRate   float64
+	Duration    time.Duration
+}
+
+var DefaultEjection = Ejection{
+	Window:      10 * time.Second,
+	MinRequests: 20,
+	ErrorRate:   0.5,
+	Duration:    30 * time.Second,
+}
+
+type BalancerOption func(*Balancer)
+
+// Balancer 持有多个 Engine 节点的连接池, 按策略选择节点发送请求;
+// 心跳失败或错误率过高的节点被摘除, 全部节点不可用时仍尝试不健康的节点
+type Balancer struct {
+	policy     BalancePolicy
+	hashKey    string
+	poolSize   int
+	dial       func(addr string) (*Client, error)
+	clientOpts []ClientOption
+	ejection   Ejection
+	heartbeat  time.Duration
+	retries    int
+	idempotent map[int64]struct{}
+	resolver   *registry.Resolver
+
+	mu     sync.Mutex
+	nodes  []*node
+	ring   []hashPoint
+	rr     int
+	closed bool
+	done   chan struct{}
+}
+
+type hashPoint struct {
+	hash uint32
+	node *node
+}
+
+// node 一个 Engine 节点
+type node struct {
+	// 进行中的请求数
+	outstanding int64
+	addr        string
+	// 平滑轮询, 由 Balancer.mu 保护
+	weight  int
+	current int
+
+	mu      sync.Mutex
+	clients []*Client
+	// 正在建立连接的槽位, 建连期间不持有锁
+	dialing map[int]*dialCall
+	next    int
+	closed  bool
+	// 心跳失败
+	down bool
+	// 错误率摘除
+	ejectedUntil time.Time
+	windowStart  time.Time
+	total        int
+	failures     int
+}
+
+// NewBalancer 以 addrs 为初始节点, 使用 SetResolver 时跟随注册中心更新
+func NewBalancer(addrs []string, opts ...BalancerOption) *Balancer {
+	b := &Balancer{
+		policy:     RoundRobin,
+		poolSize:   DefaultPoolSize,
+		ejection:   DefaultEjection,
+		heartbeat:  DefaultHeartbeat,
+		retries:    DefaultRetries,
+		idempotent: make(map[int64]struct{}),
+		done:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(b)
+		}
+	}
+	if b.dial == nil {
+		b.dial = b.dialTCP
+	}
+	b.Update(addrs...)
+	if b.resolver != nil {
+		b.resolver.Notify(b.updateInstances)
+	}
+	if b.heartbeat > 0 {
+		go b.keepalive()
+	}
+	return b
+}
+
+func (b *Balancer) dialTCP(addr string) (*Client, error) {
+	c, err := net.DialTimeout("tcp", addr, _dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(c, b.clientOpts...), nil
+}
+
+// Update 以 addrs 替换全部节点, 权重均为 1
+func (b *Balancer) Update(addrs ...string) {
+	list := make([]registry.Instance, 0, len(addrs))
+	for _, addr := range addrs {
+		list = append(list, registry.Instance{Addr: addr, Weight: 1})
+	}
+	b.updateInstances(list)
+}
+
+// updateInstances 保留仍存在节点的连接, 移除的节点在进行中请求结束后关闭
+func (b *Balancer) updateInstances(list []registry.Instance) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+
+	old := make(map[string]*node, len(b.nodes))
+	for _, n := range b.nodes {
+		old[n.addr] = n
+	}
+	nodes := make([]*node, 0, len(list))
+	for _, ins := range list {
+		weight := ins.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		n, ok := old[ins.Addr]
+		if ok {
+			delete(old, ins.Addr)
+		} else {
+			n = &node{
+				addr:    ins.Addr,
+				clients: make([]*Client, b.poolSize),
+			}
+		}
+		n.weight = weight
+		nodes = append(nodes, n)
+	}
+	for _, n := range old {
+		go n.drain()
+	}
+	b.nodes = nodes
+	b.ring = buildRing(nodes)
+}
+
+func buildRing(nodes []*node) []hashPoint {
+	var ring []hashPoint
+	for _, n := range nodes {
+		for i := 0; i < n.weight*_hashReplicas; i++ {
+			ring = append(ring, hashPoint{
+				hash: hashKey(n.addr + "#" + strconv.Itoa(i)),
+				node: n,
+			})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		return ring[i].hash < ring[j].hash
+	})
+	return ring
+}
+
+// hashKey FNV-1a 后以 murmur3 的 fmix32 打散, 相近的 key (如连续端口、数字) 在环上分布均匀
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
+
+// Addrs 当前节点地址
+func (b *Balancer) Addrs() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	addrs := make([]string, 0, len(b.nodes))
+	for _, n := range b.nodes {
+		addrs = append(addrs, n.addr)
+	}
+	return addrs
+}
+
+// Close 关闭全部连接, 不关闭 Resolver
+func (b *Balancer) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	nodes := b.nodes
+	b.nodes = nil
+	b.ring = nil
+	close(b.done)
+	b.mu.Unlock()
+
+	for _, n := range nodes {
+		n.close()
+	}
+	return nil
+}
+
+// Send 选择节点发送请求; 幂等路由连接失败或回包 ErrInternal / ErrTimeout 时换节点重试,
+// 建立连接失败的节点不计入重试次数
+func (b *Balancer) Send(ctx context.Context, id int64, header map[string]interface{}, req interface{}) (*Reply, error) {
+	attempts := 1
+	if _, ok := b.idempotent[id]; ok {
+		attempts += b.retries
+	}
+	tried := make(map[*node]struct{})
+	var (
+		r   *Reply
+		err error = ErrNoNode
+	)
+	for sent := 0; sent < attempts; {
+		n := b.pick(header, tried)
+		if n == nil {
+			break
+		}
+		tried[n] = struct{}{}
+		cl, derr := n.client(b)
+		if derr != nil {
+			n.record(b.ejection, false)
+			r, err = nil, derr
+			continue
+		}
+		sent++
+		atomic.AddInt64(&n.outstanding, 1)
+		r, err = cl.Send(ctx, id, header, req)
+		atomic.AddInt64(&n.outstanding, -1)
+		if err == nil && !isNodeFailure(r.Err()) {
+			n.record(b.ejection, true)
+			return r, nil
+		}
+		// 调用方取消或超时, 不计入节点失败也不再重试
+		if ctx.Err() != nil {
+			return r, err
+		}
+		n.record(b.ejection, false)
+	}
+	return r, err
+}
+
+// Call 同 Client.Call
+func (b *Balancer) Call(ctx context.Context, id int64, req interface{}, resp interface{}) error {
+	r, err := b.Send(ctx, id, nil, req)
+	if err != nil {
+		return err
+	}
+	if err = r.Err(); err != nil {
+		return err
+	}
+	if resp == nil {
+		return nil
+	}
+	return r.Decode(resp)
+}
+
+func (b *Balancer) CallNamed(ctx context.Context, method string, req interface{}, resp interface{}) error {
+	return b.Call(ctx, MethodID(method), req, resp)
+}
+
+// isNodeFailure 节点自身的错误, 换节点可能成功
+func isNodeFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	e, ok := code.FromError(err)
+	if !ok {
+		return true
+	}
+	return e.Code == ErrInternal.(*code.Error).Code || e.Code == ErrTimeout.(*code.Error).Code
+}
+
+// pick 在未尝试过的健康节点中选择, 没有健康节点时在未尝试过的节点中选择
+func (b *Balancer) pick(header map[string]interface{}, tried map[*node]struct{}) *node {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	var healthy, rest []*node
+	for _, n := range b.nodes {
+		if _, ok := tried[n]; ok {
+			continue
+		}
+		if n.healthy(now) {
+			healthy = append(healthy, n)
+		} else {
+			rest = append(rest, n)
+		}
+	}
+	candidates := healthy
+	if len(candidates) == 0 {
+		candidates = rest
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	switch b.policy {
+	case LeastOutstanding:
+		return b.pickLeast(candidates)
+	case ConsistentHash:
+		if v, ok := header[b.hashKey]; ok && v != nil {
+			if n := b.pickHash(fmt.Sprint(v), candidates); n != nil {
+				return n
+			}
+		}
+	}
+	return pickWeighted(candidates)
+}
+
+// pickWeighted 平滑加权轮询
+func pickWeighted(candidates []*node) *node {
+	var (
+		best  *node
+		total int
+	)
+	for _, n := range candidates {
+		n.current += n.weight
+		total += n.weight
+		if best == nil || n.current > best.current {
+			best = n
+		}
+	}
+	best.current -= total
+	return best
+}
+
+// pickLeast 进行中请求数相同时轮流选择
+func (b *Balancer) pickLeast(candidates []*node) *node {
+	b.rr++
+	var best *node
+	var bestLoad int64
+	for i := range candidates {
+		n := candidates[(b.rr+i)%len(candidates)]
+		load := atomic.LoadInt64(&n.outstanding)
+		if best == nil || load*int64(best.weight) < bestLoad*int64(n.weight) {
+			best, bestLoad = n, load
+		}
+	}
+	return best
+}
+
+// pickHash 从 key 在环上的位置顺时针找到第一个候选节点
+func (b *Balancer) pickHash(key string, candidates []*node) *node {
+	if len(b.ring) == 0 {
+		return nil
+	}
+	allowed := make(map[*node]struct{}, len(candidates))
+	for _, n := range candidates {
+		allowed[n] = struct{}{}
+	}
+	h := hashKey(key)
+	i := sort.Search(len(b.ring), func(i int) bool {
+		return b.ring[i].hash >= h
+	})
+	for j := 0; j < len(b.ring); j++ {
+		p := b.ring[(i+j)%len(b.ring)]
+		if _, ok := allowed[p.node]; ok {
+			return p.node
+		}
+	}
+	return nil
+}
+
+// keepalive 定期向每个节点发送心跳, 失败的节点摘除直到心跳恢复
+func (b *Balancer) keepalive() {
+	ticker := time.NewTicker(b.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			b.mu.Lock()
+			nodes := make([]*node, len(b.nodes))
+			copy(nodes, b.nodes)
+			b.mu.Unlock()
+
+			var wg sync.WaitGroup
+			for _, n := range nodes {
+				wg.Add(1)
+				go func(n *node) {
+					defer wg.Done()
+					n.ping(b)
+				}(n)
+			}
+			wg.Wait()
+		}
+	}
+}
+
+// dialCall 进行中的建连, 同一槽位的并发请求等待其结果
+type dialCall struct {
+	done chan struct{}
+	cl   *Client
+	err  error
+}
+
+// client 轮流使用连接池中的连接, 断开的连接在锁外重新建立,
+// 避免不可达节点在建连期间阻塞 pick 及心跳
+func (n *node) client(b *Balancer) (*Client, error) {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return nil, ErrClientClosed
+	}
+	i := n.next % len(n.clients)
+	n.next++
+	if cl := n.clients[i]; cl != nil {
+		select {
+		case <-cl.Done():
+		default:
+			n.mu.Unlock()
+			return cl, nil
+		}
+	}
+	if d, ok := n.dialing[i]; ok {
+		n.mu.Unlock()
+		<-d.done
+		return d.cl, d.err
+	}
+	d := &dialCall{done: make(chan struct{})}
+	if n.dialing == nil {
+		n.dialing = make(map[int]*dialCall)
+	}
+	n.dialing[i] = d
+	n.mu.Unlock()
+
+	d.cl, d.err = b.dial(n.addr)
+
+	n.mu.Lock()
+	delete(n.dialing, i)
+	if d.err == nil {
+		if n.closed {
+			d.cl.Close()
+			d.cl, d.err = nil, ErrClientClosed
+		} else {
+			n.clients[i] = d.cl
+		}
+	}
+	n.mu.Unlock()
+	close(d.done)
+	return d.cl, d.err
+}
+
+func (n *node) ping(b *Balancer) {
+	ctx, cancel := context.WithTimeout(context.Background(), b.heartbeat)
+	defer cancel()
+
+	cl, err := n.client(b)
+	if err == nil {
+		if err = cl.Ping(ctx); err != nil {
+			// 连接可能已失效, 关闭后下次重新建立
+			cl.Close()
+		}
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err != nil && !n.down {
+		genLogger.Write(ctx, "tcp balancer node down, addr:%s, err:%v", n.addr, err)
+	}
+	n.down = err != nil
+}
+
+func (n *node) healthy(now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return !n.down && !now.Before(n.ejectedUntil)
+}
+
+// record 统计窗口内的请求结果, 失败率达到阈值时摘除
+func (n *node) record(e Ejection, ok bool) {
+	if e.ErrorRate <= 0 {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(n.windowStart) > e.Window {
+		n.windowStart = now
+		n.total = 0
+		n.failures = 0
+	}
+	n.total++
+	if !ok {
+		n.failures++
+	}
+	if n.total >= e.MinRequests && float64(n.failures)/float64(n.total) >= e.ErrorRate {
+		genLogger.Write(context.TODO(), "tcp balancer node ejected, addr:%s, failures:%d, total:%d", n.addr, n.failures, n.total)
+		n.ejectedUntil = now.Add(e.Duration)
+		n.windowStart = now
+		n.total = 0
+		n.failures = 0
+	}
+}
+
+// drain 等待进行中的请求结束后关闭
+func (n *node) drain() {
+	deadline := time.Now().Add(_removeTimeout)
+	for atomic.LoadInt64(&n.outstanding) > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	n.close()
+}
+
+func (n *node) close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.closed = true
+	for i, cl := range n.clients {
+		if cl != nil {
+			cl.Close()
+			n.clients[i] = nil
+		}
+	}
+}
+
+// SetBalancePolicy 负载均衡策略, 默认 RoundRobin
+func SetBalancePolicy(policy BalancePolicy) BalancerOption {
+	return func(b *Balancer) {
+		b.policy = policy
+	}
+}
+
+// SetHashKey ConsistentHash 策略使用的 header key
+func SetHashKey(key string) BalancerOption {
+	return func(b *Balancer) {
+		b.hashKey = key
+	}
+}
+
+// SetPoolSize 每个节点的连接数
+func SetPoolSize(size int) BalancerOption {
+	return func(b *Balancer) {
+		if size > 0 {
+			b.poolSize = size
+		}
+	}
+}
+
+// SetBalancerClientOptions 建立连接时使用的 ClientOption
+func SetBalancerClientOptions(opts ...ClientOption) BalancerOption {
+	return func(b *Balancer) {
+		b.clientOpts = append(b.clientOpts, opts...)
+	}
+}
+
+// SetDialer 自定义建立连接, 如需鉴权可在返回前调用 Client.Authenticate
+func SetDialer(dial func(addr string) (*Client, error)) BalancerOption {
+	return func(b *Balancer) {
+		b.dial = dial
+	}
+}
+
+// SetEjection 按错误率摘除节点的规则
+func SetEjection(e Ejection) BalancerOption {
+	return func(b *Balancer) {
+		b.ejection = e
+	}
+}
+
+// SetHeartbeat 心跳间隔, 0 为不发送心跳
+func SetHeartbeat(interval time.Duration) BalancerOption {
+	return func(b *Balancer) {
+		if interval >= 0 {
+			b.heartbeat = interval
+		}
+	}
+}
+
+// SetIdempotent 可换节点重试的路由
+func SetIdempotent(ids ...int64) BalancerOption {
+	return func(b *Balancer) {
+		for _, id := range ids {
+			b.idempotent[id] = struct{}{}
+		}
+	}
+}
+
+// SetRetries 幂等路由换节点重试的次数
+func SetRetries(retries int) BalancerOption {
+	return func(b *Balancer) {
+		if retries >= 0 {
+			b.retries = retries
+		}
+	}
+}
+
+// SetResolver 节点跟随 Resolver 更新, 使用实例的权重
+func SetResolver(r *registry.Resolver) BalancerOption {
+	return func(b *Balancer) {
+		b.resolver = r
+	}
+}
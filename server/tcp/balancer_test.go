@@ -0,0 +1,305 @@
+package tcp_test
+
+import (
+	"context"
+	"errors"
+	"github.com/ousanki/sagittarius/server/tcp"
+	"github.com/ousanki/sagittarius/server/tcp/tcptest"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+var _hitID = tcp.MethodID("svc.Hit")
+
+// testNode 记录命中次数的 Engine 节点
+type testNode struct {
+	srv *tcptest.Server
+
+	mu   sync.Mutex
+	hits int
+	fail bool
+}
+
+func startNodes(t *testing.T, n int, opts ...tcp.Option) []*testNode {
+	t.Helper()
+	var nodes []*testNode
+	for i := 0; i < n; i++ {
+		nd := &testNode{}
+		e := tcp.NewApp("tcp")
+		e.WithOptions(opts...)
+		e.HandleNamed("svc.Hit", func(c *tcp.Context, req addReq) (addResp, error) {
+			nd.mu.Lock()
+			defer nd.mu.Unlock()
+			nd.hits++
+			if nd.fail {
+				return addResp{}, errors.New("boom")
+			}
+			return addResp{B: req.A}, nil
+		})
+		nd.srv = tcptest.NewServer(e, tcptest.SetLoopback())
+		t.Cleanup(func() {
+			nd.srv.Close()
+			e.Stop()
+		})
+		nodes = append(nodes, nd)
+	}
+	return nodes
+}
+
+func (nd *testNode) setFail(fail bool) {
+	nd.mu.Lock()
+	defer nd.mu.Unlock()
+	nd.fail = fail
+}
+
+// takeHits 返回并清零各节点的命中次数
+func takeHits(nodes []*testNode) []int {
+	hits := make([]int, 0, len(nodes))
+	for _, nd := range nodes {
+		nd.mu.Lock()
+		hits = append(hits, nd.hits)
+		nd.hits = 0
+		nd.mu.Unlock()
+	}
+	return hits
+}
+
+func nodeAddrs(nodes []*testNode) []string {
+	addrs := make([]string, 0, len(nodes))
+	for _, nd := range nodes {
+		addrs = append(addrs, nd.srv.Addr)
+	}
+	return addrs
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	nodes := startNodes(t, 3)
+	b := tcp.NewBalancer(nodeAddrs(nodes), tcp.SetHeartbeat(0))
+	defer b.Close()
+
+	for i := 0; i < 30; i++ {
+		var resp addResp
+		if err := b.Call(context.Background(), _hitID, addReq{A: i}, &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.B != i {
+			t.Fatalf("want %d, got %d", i, resp.B)
+		}
+	}
+	for i, n := range takeHits(nodes) {
+		if n != 10 {
+			t.Fatalf("node %d: want 10 hits, got %d", i, n)
+		}
+	}
+}
+
+func TestBalancerLeastOutstanding(t *testing.T) {
+	nodes := startNodes(t, 2)
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	for _, nd := range nodes {
+		nd.srv.Engine.HandleNamed("svc.Slow", func(c *tcp.Context, req addReq) (addResp, error) {
+			started <- struct{}{}
+			<-release
+			return addResp{}, nil
+		})
+	}
+	b := tcp.NewBalancer(nodeAddrs(nodes), tcp.SetBalancePolicy(tcp.LeastOutstanding), tcp.SetHeartbeat(0))
+	defer b.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- b.CallNamed(context.Background(), "svc.Slow", addReq{}, nil)
+	}()
+	<-started
+	for i := 0; i < 10; i++ {
+		if err := b.Call(context.Background(), _hitID, addReq{}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	// 进行中请求所在节点不再被选中
+	hits := takeHits(nodes)
+	if hits[0]+hits[1] != 10 || (hits[0] != 0 && hits[1] != 0) {
+		t.Fatalf("want all hits on the idle node, got %v", hits)
+	}
+}
+
+func TestBalancerConsistentHash(t *testing.T) {
+	nodes := startNodes(t, 3)
+	b := tcp.NewBalancer(nodeAddrs(nodes), tcp.SetBalancePolicy(tcp.ConsistentHash), tcp.SetHashKey("uid"), tcp.SetHeartbeat(0))
+	defer b.Close()
+
+	send := func(uid interface{}) {
+		r, err := b.Send(context.Background(), _hitID, map[string]interface{}{"uid": uid}, addReq{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = r.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		send(42)
+	}
+	var owners int
+	for _, n := range takeHits(nodes) {
+		if n == 20 {
+			owners++
+		}
+	}
+	if owners != 1 {
+		t.Fatal("same key was sent to more than one node")
+	}
+	// 不同 key 分布到全部节点, 比例随监听端口变化
+	for i := 0; i < 300; i++ {
+		send(i)
+	}
+	for i, n := range takeHits(nodes) {
+		if n == 0 {
+			t.Fatalf("node %d: no keys of 300", i)
+		}
+	}
+}
+
+func TestBalancerIdempotentRetry(t *testing.T) {
+	nodes := startNodes(t, 2)
+	nodes[0].setFail(true)
+	ejection := tcp.Ejection{Window: time.Minute, MinRequests: 100, ErrorRate: 0.5, Duration: time.Minute}
+
+	b := tcp.NewBalancer(nodeAddrs(nodes), tcp.SetIdempotent(_hitID), tcp.SetEjection(ejection), tcp.SetHeartbeat(0))
+	defer b.Close()
+	for i := 0; i < 4; i++ {
+		if err := b.Call(context.Background(), _hitID, addReq{}, nil); err != nil {
+			t.Fatalf("idempotent call %d: %v", i, err)
+		}
+	}
+	if hits := takeHits(nodes); hits[0] != 2 || hits[1] != 4 {
+		t.Fatalf("want [2 4] hits, got %v", hits)
+	}
+
+	// 非幂等路由不重试
+	b2 := tcp.NewBalancer(nodeAddrs(nodes), tcp.SetEjection(ejection), tcp.SetHeartbeat(0))
+	defer b2.Close()
+	var fails int
+	for i := 0; i < 4; i++ {
+		if err := b2.Call(context.Background(), _hitID, addReq{}, nil); err != nil {
+			tcptest.AssertCode(t, err, -1)
+			fails++
+		}
+	}
+	if fails != 2 {
+		t.Fatalf("want 2 failures, got %d", fails)
+	}
+}
+
+func TestBalancerEjection(t *testing.T) {
+	nodes := startNodes(t, 2)
+	nodes[0].setFail(true)
+	b := tcp.NewBalancer(nodeAddrs(nodes), tcp.SetHeartbeat(0),
+		tcp.SetEjection(tcp.Ejection{Window: time.Minute, MinRequests: 2, ErrorRate: 0.5, Duration: time.Minute}))
+	defer b.Close()
+
+	for i := 0; i < 20; i++ {
+		b.Call(context.Background(), _hitID, addReq{}, nil)
+	}
+	// 失败 2 次后摘除
+	if hits := takeHits(nodes); hits[0] != 2 || hits[1] != 18 {
+		t.Fatalf("want [2 18] hits, got %v", hits)
+	}
+}
+
+func TestBalancerHeartbeat(t *testing.T) {
+	nodes := startNodes(t, 2)
+	b := tcp.NewBalancer(nodeAddrs(nodes), tcp.SetHeartbeat(20*time.Millisecond), tcp.SetEjection(tcp.Ejection{}))
+	defer b.Close()
+
+	// 节点停止后心跳失败, 请求只发往存活节点
+	nodes[0].srv.Engine.Stop()
+	nodes[0].srv.Close()
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		if err := b.Call(context.Background(), _hitID, addReq{}, nil); err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+	}
+	if hits := takeHits(nodes); hits[1] != 10 {
+		t.Fatalf("want all hits on the live node, got %v", hits)
+	}
+}
+
+func TestPingBeforeAuth(t *testing.T) {
+	e := tcp.NewApp("tcp")
+	e.WithOptions(tcp.SetAuthenticator(tcp.AuthenticatorFunc(func(ctx context.Context, header map[string]interface{}, body []byte) (interface{}, error) {
+		return "user", nil
+	}), time.Second))
+	e.HandleNamed("svc.Hit", func(c *tcp.Context, req addReq) (addResp, error) {
+		return addResp{B: req.A}, nil
+	})
+	srv := tcptest.NewServer(e)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := srv.Client.Ping(ctx); err != nil {
+		t.Fatalf("ping before auth: %v", err)
+	}
+	tcptest.AssertCode(t, srv.Call(_hitID, addReq{}, nil), -3)
+	if err := srv.Client.Authenticate(ctx, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Call(_hitID, addReq{A: 1}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBalancerSlowDial(t *testing.T) {
+	nodes := startNodes(t, 1)
+	const slow = "slow:1"
+	dialing := make(chan struct{})
+	release := make(chan struct{})
+	b := tcp.NewBalancer([]string{slow, nodes[0].srv.Addr}, tcp.SetHeartbeat(0), tcp.SetRetries(0),
+		tcp.SetDialer(func(addr string) (*tcp.Client, error) {
+			if addr == slow {
+				close(dialing)
+				<-release
+				return nil, errors.New("unreachable")
+			}
+			c, err := net.Dial("tcp", addr)
+			if err != nil {
+				return nil, err
+			}
+			return tcp.NewClient(c), nil
+		}))
+	defer b.Close()
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	// 失败时先放行建连, 避免 Close 等待
+	defer unblock()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- b.Call(context.Background(), _hitID, addReq{}, nil)
+	}()
+	<-dialing
+	// 建连期间其它节点的请求不受阻塞
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Call(context.Background(), _hitID, addReq{}, nil)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("call blocked by a dialing node")
+	}
+	unblock()
+	<-errc
+}
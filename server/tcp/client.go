@@ -0,0 +1,429 @@
+package tcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/opentracing/opentracing-go"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// HeaderSeq 客户端请求序号, Engine 回包时原样带回用于匹配
+const HeaderSeq = "_seq"
+
+var ErrClientClosed = errors.New("tcp: client closed")
+
+type ClientOption func(*Client)
+
+// Client 与 Engine 通信的客户端, 同一连接上可并发请求及多路流
+type Client struct {
+	conn      net.Conn
+	version   uint8
+	checksum  bool
+	withTrace int8
+	window    int64
+	onReply   func(*Reply)
+	// 紧凑 header
+	binaryHeader bool
+	// 回包长度上限
+	maxFrameSize int64
+
+	wmu sync.Mutex
+
+	mu       sync.Mutex
+	seq      int64
+	pending  map[int64]chan *Reply
+	streamID int64
+	streams  map[int64]*stream
+	err      error
+	done     chan struct{}
+}
+
+// Reply 一次回包
+type Reply struct {
+	ID     int64
+	Header map[string]interface{}
+	Body   []byte
+	// 回包携带 trace 时的 SpanContext
+	Span opentracing.SpanContext
+}
+
+// Err 回包 header 中携带的 code.Error
+func (r *Reply) Err() error {
+	if e := HeaderError(r.Header); e != nil {
+		return e
+	}
+	return nil
+}
+
+func (r *Reply) Decode(v interface{}) error {
+	if len(r.Body) == 0 {
+		return nil
+	}
+	return json.Unmarshal(r.Body, v)
+}
+
+func Dial(addr string, opts ...ClientOption) (*Client, error) {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(c, opts...), nil
+}
+
+// NewClient 在已建立的连接上创建客户端
+func NewClient(conn net.Conn, opts ...ClientOption) *Client {
+	cl := &Client{
+		conn:         conn,
+		version:      ProtoVersion,
+		window:       DefaultStreamWindow,
+		pending:      make(map[int64]chan *Reply),
+		maxFrameSize: DefaultMaxFrameSize,
+		streams:      make(map[int64]*stream),
+		done:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(cl)
+		}
+	}
+	go cl.loop()
+	return cl
+}
+
+func (cl *Client) Close() error {
+	cl.fail(ErrClientClosed)
+	return cl.conn.Close()
+}
+
+// Done 连接断开后关闭
+func (cl *Client) Done() <-chan struct{} {
+	return cl.done
+}
+
+// Err 连接断开的原因
+func (cl *Client) Err() error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.err
+}
+
+// Send 发送请求并等待同序号回包
+func (cl *Client) Send(ctx context.Context, id int64, header map[string]interface{}, req interface{}) (*Reply, error) {
+	bv, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	return cl.SendRaw(ctx, id, header, bv)
+}
+
+// SendRaw 同 Send, body 原样发送不做 JSON 编码
+func (cl *Client) SendRaw(ctx context.Context, id int64, header map[string]interface{}, bv []byte) (*Reply, error) {
+	ch := make(chan *Reply, 1)
+	cl.mu.Lock()
+	if cl.err != nil {
+		cl.mu.Unlock()
+		return nil, cl.err
+	}
+	cl.seq++
+	seq := cl.seq
+	cl.pending[seq] = ch
+	cl.mu.Unlock()
+	defer func() {
+		cl.mu.Lock()
+		delete(cl.pending, seq)
+		cl.mu.Unlock()
+	}()
+
+	values := make(map[string]interface{}, len(header)+1)
+	for k, v := range header {
+		values[k] = v
+	}
+	values[HeaderSeq] = seq
+	// 告知服务端剩余等待时长
+	if d, ok := ctx.Deadline(); ok {
+		ms := int64(time.Until(d) / time.Millisecond)
+		if ms <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+		values[HeaderTimeout] = ms
+	}
+	err := cl.write(ctx, cl.headerBase(id, 0, cl.withTrace), streamBase{}, values, bv)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case r := <-ch:
+		return r, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-cl.done:
+		return nil, cl.Err()
+	}
+}
+
+// Call 发送请求, 回包错误码转换为 code.Error, 否则解码至 resp
+func (cl *Client) Call(ctx context.Context, id int64, req interface{}, resp interface{}) error {
+	r, err := cl.Send(ctx, id, nil, req)
+	if err != nil {
+		return err
+	}
+	if err = r.Err(); err != nil {
+		return err
+	}
+	if resp == nil {
+		return nil
+	}
+	return r.Decode(resp)
+}
+
+func (cl *Client) CallNamed(ctx context.Context, method string, req interface{}, resp interface{}) error {
+	return cl.Call(ctx, MethodID(method), req, resp)
+}
+
+// OpenStream 打开流, ctx 取消时向对端发送 Reset
+func (cl *Client) OpenStream(ctx context.Context, id int64, header map[string]interface{}) (*ClientStream, error) {
+	hb := cl.headerBase(id, FlagStream, UnUseTracer)
+	sctx, cancel := context.WithCancel(ctx)
+	cl.mu.Lock()
+	if cl.err != nil {
+		cl.mu.Unlock()
+		cancel()
+		return nil, cl.err
+	}
+	cl.streamID++
+	st := newStream(cl.streamID, cl.window, func(sb streamBase, payload []byte) error {
+		return cl.write(context.Background(), hb, sb, nil, payload)
+	})
+	st.cancel = cancel
+	cl.streams[st.id] = st
+	cl.mu.Unlock()
+
+	open := cl.headerBase(id, FlagStream, cl.withTrace)
+	err := cl.write(ctx, open, streamBase{StreamID: st.id, Kind: StreamOpen, Window: st.window - DefaultStreamWindow}, header, nil)
+	if err != nil {
+		cl.removeStream(st)
+		return nil, err
+	}
+	go func() {
+		<-sctx.Done()
+		if ctx.Err() != nil {
+			st.reset(ctx.Err().Error())
+			cl.removeStream(st)
+		}
+	}()
+	return &ClientStream{
+		ctx: sctx,
+		st:  st,
+		dec: json.NewDecoder(st),
+		enc: json.NewEncoder(st),
+	}, nil
+}
+
+func (cl *Client) headerBase(id int64, flag uint8, withTrace int8) headerBase {
+	hb := headerBase{
+		Magic:     Magic,
+		Version:   cl.version,
+		Flag:      flag,
+		WithTrace: withTrace,
+		ID:        id,
+	}
+	if cl.checksum {
+		hb.Flag |= FlagChecksum
+	}
+	if cl.binaryHeader {
+		hb.Flag |= FlagBinaryHeader
+	}
+	return hb
+}
+
+func (cl *Client) write(ctx context.Context, hb headerBase, sb streamBase, values map[string]interface{}, bv []byte) error {
+	cl.wmu.Lock()
+	defer cl.wmu.Unlock()
+	return writeRaw(ctx, hb, sb, values, bv, cl.conn)
+}
+
+func (cl *Client) loop() {
+	versions := map[uint8]struct{}{cl.version: {}}
+	for {
+		h, b, spCtx, err := readFrame(cl.conn, versions, cl.maxFrameSize)
+		if err != nil {
+			cl.fail(err)
+			return
+		}
+		if h.HasFlag(FlagStream) {
+			cl.dispatchStream(h, b)
+			continue
+		}
+		r := &Reply{ID: h.GetID(), Header: h.values, Body: b.buf, Span: spCtx}
+		if cl.onReply != nil {
+			cl.onReply(r)
+		}
+		seq, _ := headerInt64(h.values[HeaderSeq])
+		cl.mu.Lock()
+		ch := cl.pending[seq]
+		cl.mu.Unlock()
+		if ch == nil {
+			continue
+		}
+		select {
+		case ch <- r:
+		default:
+		}
+	}
+}
+
+func (cl *Client) dispatchStream(h *Header, b *Body) {
+	sb := h.stream
+	cl.mu.Lock()
+	st := cl.streams[sb.StreamID]
+	cl.mu.Unlock()
+	if st == nil {
+		return
+	}
+	switch sb.Kind {
+	case StreamData:
+		if err := st.push(b.buf); err != nil {
+			st.reset(err.Error())
+			cl.removeStream(st)
+		}
+	case StreamEnd:
+		// 对端处理函数已返回, 已缓冲的数据仍可读取
+		st.finish()
+		cl.removeStream(st)
+	case StreamWindow:
+		if sb.Window <= 0 {
+			st.reset(ErrFlowControl.Error())
+			cl.removeStream(st)
+			return
+		}
+		st.grant(sb.Window)
+	case StreamReset:
+		st.abort(ErrStreamReset)
+		cl.removeStream(st)
+	}
+}
+
+// removeStream 移除并结束流的 Ctx
+func (cl *Client) removeStream(st *stream) {
+	cl.mu.Lock()
+	delete(cl.streams, st.id)
+	cl.mu.Unlock()
+	st.cancel()
+}
+
+func (cl *Client) fail(err error) {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.err != nil {
+		return
+	}
+	cl.err = err
+	close(cl.done)
+	for id, st := range cl.streams {
+		st.abort(err)
+		delete(cl.streams, id)
+	}
+}
+
+// ClientStream 客户端流, 每条消息为一个 JSON 值
+type ClientStream struct {
+	ctx context.Context
+	st  *stream
+	dec *json.Decoder
+	enc *json.Encoder
+}
+
+func (s *ClientStream) Ctx() context.Context {
+	return s.ctx
+}
+
+// Recv 读取下一条消息, 对端结束后返回 io.EOF
+func (s *ClientStream) Recv(v interface{}) error {
+	return s.dec.Decode(v)
+}
+
+func (s *ClientStream) Send(v interface{}) error {
+	return s.enc.Encode(v)
+}
+
+// CloseSend 半关闭, 仍可继续 Recv
+func (s *ClientStream) CloseSend() error {
+	return s.st.Close()
+}
+
+func (s *ClientStream) Reset(reason string) error {
+	return s.st.reset(reason)
+}
+
+// Read 按字节读取, 与 Recv 不可混用
+func (s *ClientStream) Read(p []byte) (int, error) {
+	return s.st.Read(p)
+}
+
+// Write 按字节写入, 与 Send 不可混用
+func (s *ClientStream) Write(p []byte) (int, error) {
+	return s.st.Write(p)
+}
+
+func (s *ClientStream) Close() error {
+	return s.st.Close()
+}
+
+func SetClientVersion(version uint8) ClientOption {
+	return func(cl *Client) {
+		cl.version = version
+	}
+}
+
+func SetClientChecksum(checksum bool) ClientOption {
+	return func(cl *Client) {
+		cl.checksum = checksum
+	}
+}
+
+// SetClientTrace 请求携带 ctx 中的 trace, UseTraceContext 为 W3C header, UseTracer 为 opentracing Binary
+func SetClientTrace(withTrace int8) ClientOption {
+	return func(cl *Client) {
+		cl.withTrace = withTrace
+	}
+}
+
+// SetClientOnReply 每个非流回包均回调, 包括无对应请求的推送
+func SetClientOnReply(fn func(*Reply)) ClientOption {
+	return func(cl *Client) {
+		cl.onReply = fn
+	}
+}
+
+// SetClientBinaryHeader header 使用紧凑二进制编码, Engine 以同样编码回包
+func SetClientBinaryHeader(binary bool) ClientOption {
+	return func(cl *Client) {
+		cl.binaryHeader = binary
+	}
+}
+
+// SetClientStreamWindow 设置每个流的接收窗口, 不小于 DefaultStreamWindow
+func SetClientStreamWindow(window int64) ClientOption {
+	return func(cl *Client) {
+		if window > DefaultStreamWindow {
+			cl.window = window
+		}
+	}
+}
+
+// SetClientMaxFrameSize 设置回包的长度上限, 超过时断开连接, 默认 DefaultMaxFrameSize
+func SetClientMaxFrameSize(size int64) ClientOption {
+	return func(cl *Client) {
+		if size > 0 {
+			cl.maxFrameSize = size
+		}
+	}
+}
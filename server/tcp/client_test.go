@@ -0,0 +1,163 @@
+package tcp_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ousanki/sagittarius/server/tcp"
+	"github.com/ousanki/sagittarius/server/tcp/tcptest"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestClientChecksum(t *testing.T) {
+	for _, checksum := range []bool{false, true} {
+		e := tcp.NewApp("tcp")
+		e.WithOptions(tcp.SetChecksum(checksum))
+		id := e.HandleNamed("svc.Add", add)
+		srv := tcptest.NewServer(e, tcptest.SetClientOptions(tcp.SetClientChecksum(!checksum)))
+
+		var resp addResp
+		if err := srv.Call(id, addReq{A: 1}, &resp); err != nil || resp.B != 2 {
+			t.Fatalf("checksum %v: want 2, got %v %v", checksum, resp.B, err)
+		}
+		srv.Close()
+	}
+}
+
+func TestClientBidiStream(t *testing.T) {
+	e := tcp.NewApp("tcp")
+	e.BidiStream(1, func(s *tcp.Stream) error {
+		for {
+			var req addReq
+			if err := s.Recv(&req); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+			if err := s.Send(addResp{B: req.A * 2}); err != nil {
+				return err
+			}
+		}
+	})
+	srv := tcptest.NewServer(e)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), tcptest.DefaultTimeout)
+	defer cancel()
+	cs, err := srv.Client.OpenStream(ctx, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err = cs.Send(addReq{A: i}); err != nil {
+			t.Fatal(err)
+		}
+		var resp addResp
+		if err = cs.Recv(&resp); err != nil || resp.B != i*2 {
+			t.Fatalf("message %d: want %d, got %v %v", i, i*2, resp.B, err)
+		}
+	}
+	cs.CloseSend()
+	var resp addResp
+	if err = cs.Recv(&resp); err != io.EOF {
+		t.Fatalf("want io.EOF after the handler returns, got %v", err)
+	}
+
+	// 非流式请求立即回包 ErrBadRequest
+	tcptest.AssertCode(t, srv.Call(1, addReq{}, nil), -5)
+}
+
+func TestClientStreamLargeBody(t *testing.T) {
+	e := tcp.NewApp("tcp")
+	e.Invoke(1, func(c *tcp.Context) {
+		n, err := io.Copy(ioutil.Discard, c.BodyReader())
+		w, werr := c.BodyWriter()
+		if werr != nil {
+			return
+		}
+		fmt.Fprintf(w, "%d %v", n, err)
+		w.Close()
+	})
+	srv := tcptest.NewServer(e)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), tcptest.DefaultTimeout)
+	defer cancel()
+	cs, err := srv.Client.OpenStream(ctx, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 超过默认窗口, 分多帧并等待窗口更新
+	body := bytes.Repeat([]byte("x"), 4*int(tcp.DefaultStreamWindow)+1)
+	if _, err = cs.Write(body); err != nil {
+		t.Fatal(err)
+	}
+	cs.Close()
+	got, err := ioutil.ReadAll(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("%d <nil>", len(body)); string(got) != want {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+}
+
+func TestClientRoutes(t *testing.T) {
+	e := tcp.NewApp("tcp")
+	id := e.Name("svc").HandleNamed("svc.Add", add)
+	srv := tcptest.NewServer(e)
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	if err := e.ExportRoutes(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var routes []tcp.RouteInfo
+	if err := json.Unmarshal(buf.Bytes(), &routes); err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 1 || routes[0].ID != id || routes[0].Method != "svc.Add" || routes[0].Group != "svc" {
+		t.Fatalf("unexpected route table %+v", routes)
+	}
+
+	if err := srv.Client.CallNamed(context.Background(), "svc.Add", addReq{A: 1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	// 运行时移除后回包 ErrNotFound
+	if !e.Remove(id) || e.Remove(id) {
+		t.Fatal("want the route removed exactly once")
+	}
+	tcptest.AssertCode(t, srv.Call(id, addReq{}, nil), -6)
+}
+
+func TestClientTraceContext(t *testing.T) {
+	e := tcp.NewApp("tcp")
+	got := make(chan trace.SpanContext, 1)
+	e.Invoke(1, func(c *tcp.Context) {
+		got <- trace.SpanContextFromContext(c.Ctx())
+		c.Write(1, nil)
+	})
+	srv := tcptest.NewServer(e, tcptest.SetClientOptions(tcp.SetClientTrace(tcp.UseTraceContext)))
+	defer srv.Close()
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3},
+		SpanID:     trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx, cancel := context.WithTimeout(trace.ContextWithSpanContext(context.Background(), sc), time.Second)
+	defer cancel()
+	if _, err := srv.SendContext(ctx, 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	remote := <-got
+	if remote.TraceID() != sc.TraceID() || remote.SpanID() != sc.SpanID() || !remote.IsRemote() {
+		t.Fatalf("want remote span context %v, got %v", sc, remote)
+	}
+}
@@ -0,0 +1,270 @@
+package tcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+)
+
+// 紧凑 header 的值类型
+const (
+	_valueNil uint8 = iota
+	_valueFalse
+	_valueTrue
+	_valueInt
+	_valueFloat
+	_valueString
+	// 其余类型以 JSON 编码
+	_valueJSON
+	// 超出 int64 的无符号整数, uvarint
+	_valueUint
+)
+
+var ErrBadHeader = errors.New("tcp: malformed binary header")
+
+// encodeHeader 按 FlagBinaryHeader 选择 header 编码
+func encodeHeader(flag uint8, values map[string]interface{}) ([]byte, error) {
+	if flag&FlagBinaryHeader == 0 {
+		return json.Marshal(values)
+	}
+	return appendBinaryHeader(nil, values)
+}
+
+// decodeHeader 两种编码的数字均解码为 float64, 与 JSON 解码一致;
+// 精确的整数值由 decodeNumbers 按需解码
+func decodeHeader(flag uint8, buf []byte, values map[string]interface{}) error {
+	if flag&FlagBinaryHeader == 0 {
+		return json.Unmarshal(buf, &values)
+	}
+	return readBinaryHeader(buf, values, false)
+}
+
+// decodeNumbers 数字解码为 json.Number, 大于 2^53 的整数不丢失精度
+func decodeNumbers(flag uint8, buf []byte) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	if flag&FlagBinaryHeader == 0 {
+		return values, UnmarshalUseNumber(buf, &values)
+	}
+	return values, readBinaryHeader(buf, values, true)
+}
+
+// UnmarshalUseNumber 同 json.Unmarshal, 数字解码为 json.Number
+func UnmarshalUseNumber(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("tcp: invalid character after top-level value")
+	}
+	return nil
+}
+
+// appendBinaryHeader 格式: 个数 uvarint, 每项 key 长度 uvarint + key + 类型 + 值;
+// 整数为 zigzag varint, 超出 int64 的无符号整数为 uvarint, 浮点为 8 字节,
+// 字符串及 JSON 为长度 uvarint + 字节; 空 header 为 0 字节
+func appendBinaryHeader(b []byte, values map[string]interface{}) ([]byte, error) {
+	if len(values) == 0 {
+		return b, nil
+	}
+	b = appendUvarint(b, uint64(len(values)))
+	for k, v := range values {
+		b = appendString(b, k)
+		switch x := v.(type) {
+		case nil:
+			b = append(b, _valueNil)
+		case bool:
+			if x {
+				b = append(b, _valueTrue)
+			} else {
+				b = append(b, _valueFalse)
+			}
+		case int:
+			b = appendVarint(append(b, _valueInt), int64(x))
+		case int8:
+			b = appendVarint(append(b, _valueInt), int64(x))
+		case int16:
+			b = appendVarint(append(b, _valueInt), int64(x))
+		case int32:
+			b = appendVarint(append(b, _valueInt), int64(x))
+		case int64:
+			b = appendVarint(append(b, _valueInt), x)
+		case uint8:
+			b = appendVarint(append(b, _valueInt), int64(x))
+		case uint16:
+			b = appendVarint(append(b, _valueInt), int64(x))
+		case uint32:
+			b = appendVarint(append(b, _valueInt), int64(x))
+		case uint:
+			b = appendUint(b, uint64(x))
+		case uint64:
+			b = appendUint(b, x)
+		case float32:
+			b = appendFloat(append(b, _valueFloat), float64(x))
+		case float64:
+			b = appendFloat(append(b, _valueFloat), x)
+		case string:
+			b = appendString(append(b, _valueString), x)
+		case json.Number:
+			if n, err := x.Int64(); err == nil {
+				b = appendVarint(append(b, _valueInt), n)
+			} else if f, err := x.Float64(); err == nil {
+				b = appendFloat(append(b, _valueFloat), f)
+			} else {
+				b = appendString(append(b, _valueString), string(x))
+			}
+		default:
+			bs, err := json.Marshal(x)
+			if err != nil {
+				return nil, err
+			}
+			b = appendString(append(b, _valueJSON), string(bs))
+		}
+	}
+	return b, nil
+}
+
+// readBinaryHeader 数字解码为 float64, useNumber 时整数解码为 json.Number
+func readBinaryHeader(buf []byte, values map[string]interface{}, useNumber bool) error {
+	if len(buf) == 0 {
+		return nil
+	}
+	n, buf, err := readUvarint(buf)
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < n; i++ {
+		var (
+			key string
+			raw []byte
+		)
+		if key, buf, err = readString(buf); err != nil {
+			return err
+		}
+		if len(buf) == 0 {
+			return ErrBadHeader
+		}
+		kind := buf[0]
+		buf = buf[1:]
+		switch kind {
+		case _valueNil:
+			values[key] = nil
+		case _valueFalse:
+			values[key] = false
+		case _valueTrue:
+			values[key] = true
+		case _valueInt:
+			v, m := binary.Varint(buf)
+			if m <= 0 {
+				return ErrBadHeader
+			}
+			if useNumber {
+				values[key] = json.Number(strconv.FormatInt(v, 10))
+			} else {
+				values[key] = float64(v)
+			}
+			buf = buf[m:]
+		case _valueUint:
+			var v uint64
+			if v, buf, err = readUvarint(buf); err != nil {
+				return err
+			}
+			if useNumber {
+				values[key] = json.Number(strconv.FormatUint(v, 10))
+			} else {
+				values[key] = float64(v)
+			}
+		case _valueFloat:
+			if len(buf) < 8 {
+				return ErrBadHeader
+			}
+			values[key] = math.Float64frombits(binary.BigEndian.Uint64(buf))
+			buf = buf[8:]
+		case _valueString:
+			var s string
+			if s, buf, err = readString(buf); err != nil {
+				return err
+			}
+			values[key] = s
+		case _valueJSON:
+			if raw, buf, err = readBytes(buf); err != nil {
+				return err
+			}
+			var v interface{}
+			if useNumber {
+				err = UnmarshalUseNumber(raw, &v)
+			} else {
+				err = json.Unmarshal(raw, &v)
+			}
+			if err != nil {
+				return err
+			}
+			values[key] = v
+		default:
+			return ErrBadHeader
+		}
+	}
+	if len(buf) != 0 {
+		return ErrBadHeader
+	}
+	return nil
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(b, tmp[:binary.PutUvarint(tmp[:], v)]...)
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(b, tmp[:binary.PutVarint(tmp[:], v)]...)
+}
+
+func appendUint(b []byte, v uint64) []byte {
+	if v <= math.MaxInt64 {
+		return appendVarint(append(b, _valueInt), int64(v))
+	}
+	return appendUvarint(append(b, _valueUint), v)
+}
+
+func appendFloat(b []byte, f float64) []byte {
+	var tmp [8]byte
+	binary.BigEndian.PutUint64(tmp[:], math.Float64bits(f))
+	return append(b, tmp[:]...)
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(appendUvarint(b, uint64(len(s))), s...)
+}
+
+func readUvarint(buf []byte) (uint64, []byte, error) {
+	v, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return 0, nil, ErrBadHeader
+	}
+	return v, buf[n:], nil
+}
+
+func readBytes(buf []byte) ([]byte, []byte, error) {
+	n, buf, err := readUvarint(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	if uint64(len(buf)) < n {
+		return nil, nil, ErrBadHeader
+	}
+	return buf[:n], buf[n:], nil
+}
+
+func readString(buf []byte) (string, []byte, error) {
+	bs, buf, err := readBytes(buf)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(bs), buf, nil
+}
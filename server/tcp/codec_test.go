@@ -0,0 +1,188 @@
+package tcp
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestBinaryHeaderRoundTrip(t *testing.T) {
+	in := map[string]interface{}{
+		"nil":    nil,
+		"true":   true,
+		"false":  false,
+		"int":    -42,
+		"int8":   int8(-8),
+		"int32":  int32(1 << 30),
+		"int64":  int64(math.MinInt64),
+		"uint8":  uint8(255),
+		"uint32": uint32(math.MaxUint32),
+		"uint":   uint(7),
+		"uint64": uint64(1<<53 + 1),
+		"maxu64": uint64(math.MaxUint64),
+		"float":  1.5,
+		"string": "hello",
+		"empty":  "",
+		"list":   []int{1, 2},
+	}
+	want := map[string]interface{}{
+		"nil":    nil,
+		"true":   true,
+		"false":  false,
+		"int":    float64(-42),
+		"int8":   float64(-8),
+		"int32":  float64(1 << 30),
+		"int64":  float64(math.MinInt64),
+		"uint8":  float64(255),
+		"uint32": float64(math.MaxUint32),
+		"uint":   float64(7),
+		"uint64": float64(1<<53 + 1),
+		"maxu64": float64(math.MaxUint64),
+		"float":  1.5,
+		"string": "hello",
+		"empty":  "",
+		"list":   []interface{}{float64(1), float64(2)},
+	}
+	buf, err := encodeHeader(FlagBinaryHeader, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]interface{})
+	if err = decodeHeader(FlagBinaryHeader, buf, got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("round trip mismatch\nwant %#v\ngot  %#v", want, got)
+	}
+
+	// 精确解码整数不丢失精度
+	if got, err = decodeNumbers(FlagBinaryHeader, buf); err != nil {
+		t.Fatal(err)
+	}
+	for k, n := range map[string]json.Number{
+		"int":    "-42",
+		"int64":  "-9223372036854775808",
+		"uint64": "9007199254740993",
+		"maxu64": "18446744073709551615",
+	} {
+		if got[k] != n {
+			t.Fatalf("key %s: want %s, got %#v", k, n, got[k])
+		}
+	}
+	if !reflect.DeepEqual(got["list"], []interface{}{json.Number("1"), json.Number("2")}) {
+		t.Fatalf("want json.Number list, got %#v", got["list"])
+	}
+}
+
+func TestBinaryHeaderEmpty(t *testing.T) {
+	buf, err := encodeHeader(FlagBinaryHeader, nil)
+	if err != nil || len(buf) != 0 {
+		t.Fatalf("want empty encoding, got %v %v", buf, err)
+	}
+	got := make(map[string]interface{})
+	if err = decodeHeader(FlagBinaryHeader, buf, got); err != nil || len(got) != 0 {
+		t.Fatalf("want empty header, got %v %v", got, err)
+	}
+}
+
+func TestBinaryHeaderMalformed(t *testing.T) {
+	buf, err := encodeHeader(FlagBinaryHeader, map[string]interface{}{"k": "value", "n": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 1; n < len(buf); n++ {
+		if err = decodeHeader(FlagBinaryHeader, buf[:n], make(map[string]interface{})); err == nil {
+			t.Fatalf("truncated at %d: want error", n)
+		}
+	}
+	if err = decodeHeader(FlagBinaryHeader, append(buf, 0), make(map[string]interface{})); err != ErrBadHeader {
+		t.Fatalf("trailing bytes: want ErrBadHeader, got %v", err)
+	}
+}
+
+func TestJSONHeaderRoundTrip(t *testing.T) {
+	in := map[string]interface{}{"s": "x", "b": true, "f": 1.5}
+	buf, err := encodeHeader(0, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]interface{})
+	if err = decodeHeader(0, buf, got); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range in {
+		if n, ok := headerFloat64(got[k]); ok {
+			got[k] = n
+		}
+		if !reflect.DeepEqual(got[k], v) {
+			t.Fatalf("key %s: want %v, got %v", k, v, got[k])
+		}
+	}
+}
+
+var _benchHeader = map[string]interface{}{
+	HeaderSeq:     int64(12345),
+	HeaderTimeout: int64(3000),
+	"uid":         int64(10086),
+	"token":       "4f2c1a9e0b7d",
+	"lang":        "zh-CN",
+	"debug":       false,
+}
+
+func BenchmarkEncodeHeaderJSON(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		encodeHeader(0, _benchHeader)
+	}
+}
+
+func BenchmarkEncodeHeaderBinary(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		encodeHeader(FlagBinaryHeader, _benchHeader)
+	}
+}
+
+func BenchmarkDecodeHeaderJSON(b *testing.B) {
+	buf, _ := encodeHeader(0, _benchHeader)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		decodeHeader(0, buf, make(map[string]interface{}))
+	}
+}
+
+func BenchmarkDecodeHeaderBinary(b *testing.B) {
+	buf, _ := encodeHeader(FlagBinaryHeader, _benchHeader)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		decodeHeader(FlagBinaryHeader, buf, make(map[string]interface{}))
+	}
+}
+
+func TestJSONHeaderLargeInt(t *testing.T) {
+	const id = int64(1<<53 + 1)
+	buf, err := encodeHeader(0, map[string]interface{}{"id": id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 与旧版本一致, 数字为 float64
+	got := make(map[string]interface{})
+	if err = decodeHeader(0, buf, got); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got["id"].(float64); !ok {
+		t.Fatalf("want float64, got %#v", got["id"])
+	}
+	if got, err = decodeNumbers(0, buf); err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := headerInt64(got["id"]); !ok || n != id {
+		t.Fatalf("want %d, got %v", id, got["id"])
+	}
+	if _, err = decodeNumbers(0, []byte(`{"a":1} x`)); err == nil {
+		t.Fatal("want error for trailing data")
+	}
+}
@@ -1,22 +1,47 @@
 package tcp
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"net"
+	"sync"
+	"time"
 )
 
 type core func(*Context)
 
+// WriteHook Context.Write 写出前回调, body 为编码后的字节
+type WriteHook func(id int64, header map[string]interface{}, body []byte)
+
+// Context 单个请求的上下文, 取自对象池, 处理链返回后归还;
+// 处理函数返回后不可继续持有, 异步推送使用 Session
 type Context struct {
 	ctx       context.Context
 	conn      net.Conn
+	session   *conn
+	stream    *stream
+	route     *route
+	hooks     []WriteHook
+	hmu       sync.Mutex
 	cores     []core
 	header    *Header
 	body      *Body
 	index     int8
 	withTrace int8
+	checksum  bool
+	// 非 tcp 连接 (如 http 网关) 的回包出口
+	reply WriteHook
+	// 截止时间的取消函数及回包状态
+	cancel func()
+	state  int32
+	// 鉴权得到的身份
+	principal interface{}
+	// 回包 header 及是否回显请求 header
+	respHeader map[string]interface{}
+	echo       bool
 }
 
 func newContext() *Context {
@@ -34,10 +59,21 @@ func newContext() *Context {
 
 func (c *Context) reset() {
 	c.withTrace = UnUseTracer
+	c.checksum = false
 	c.body = nil
 	c.header = nil
 	c.index = 0
 	c.conn = nil
+	c.session = nil
+	c.stream = nil
+	c.route = nil
+	c.hooks = nil
+	c.reply = nil
+	c.cancel = nil
+	c.state = 0
+	c.principal = nil
+	c.respHeader = nil
+	c.echo = false
 	c.cores = nil
 	c.ctx = context.TODO()
 }
@@ -47,6 +83,18 @@ func (c *Context) Build(ctx context.Context, conn net.Conn) {
 	c.conn = conn
 }
 
+func (c *Context) bindRoute(r *route) {
+	if r == nil {
+		c.cores = _notFound
+		return
+	}
+	c.route = r
+	c.cores = r.cores
+	if r.timeout > 0 {
+		c.setDeadline(time.Now().Add(r.timeout))
+	}
+}
+
 func (c *Context) do() {
 	for c.index < int8(len(c.cores)) {
 		c.cores[c.index](c)
@@ -58,6 +106,19 @@ func (c *Context) Ctx() context.Context {
 	return c.ctx
 }
 
+// SetCtx 替换 Ctx, 供中间件附加 span 等值, 须派生自原 Ctx
+func (c *Context) SetCtx(ctx context.Context) {
+	c.ctx = ctx
+}
+
+// Method 通过 InvokeNamed 注册的方法名, 数字路由为空
+func (c *Context) Method() string {
+	if c.route == nil {
+		return ""
+	}
+	return c.route.method
+}
+
 func (c *Context) Next() {
 	c.index++
 	for c.index < int8(len(c.cores)) {
@@ -66,6 +127,21 @@ func (c *Context) Next() {
 	}
 }
 
+// ID 请求的路由 ID
+func (c *Context) ID() int64 {
+	return c.header.GetID()
+}
+
+// HeaderValues 请求 header 的全部值, 回显请求 header 时修改会影响回包
+func (c *Context) HeaderValues() map[string]interface{} {
+	return c.header.values
+}
+
+// Body 请求 body 的原始字节
+func (c *Context) Body() []byte {
+	return c.body.buf
+}
+
 func (c *Context) GetHeaderValue(key string) interface{} {
 	if v, ok := c.header.values[key]; ok {
 		return v
@@ -96,5 +172,70 @@ func (c *Context) WithTrace(with int8) {
 }
 
 func (c *Context) Write(id int64, data interface{}) error {
-	return Write(c.ctx, c.withTrace, c.header.values, id, data, c.conn)
+	if !c.markWritten() {
+		return ErrTimeout
+	}
+	bv, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return c.write(c.ctx, id, c.replyValues(), bv)
+}
+
+// write 超时回包时与处理链并发, ctx 由调用方传入
+func (c *Context) write(ctx context.Context, id int64, values map[string]interface{}, bv []byte) error {
+	hb := headerBase{
+		Magic:     Magic,
+		Version:   c.header.Version,
+		ID:        id,
+		WithTrace: c.withTrace,
+	}
+	if c.header.HasFlag(FlagChecksum) || c.checksum {
+		hb.Flag |= FlagChecksum
+	}
+	if c.header.HasFlag(FlagBinaryHeader) {
+		hb.Flag |= FlagBinaryHeader
+	}
+	c.hmu.Lock()
+	hooks := c.hooks
+	c.hmu.Unlock()
+	for _, hook := range hooks {
+		hook(id, values, bv)
+	}
+	if c.reply != nil {
+		c.reply(id, values, bv)
+		return nil
+	}
+	if c.session != nil {
+		return c.session.write(ctx, hb, streamBase{}, values, bv)
+	}
+	return writeRaw(ctx, hb, streamBase{}, values, bv, c.conn)
+}
+
+// OnWrite 注册回包观察者, 供录制等中间件使用
+func (c *Context) OnWrite(hook WriteHook) {
+	c.hmu.Lock()
+	defer c.hmu.Unlock()
+	c.hooks = append(c.hooks, hook)
+}
+
+// IsStream 是否为流式请求
+func (c *Context) IsStream() bool {
+	return c.stream != nil
+}
+
+// BodyReader 流式请求按块读取直到对端 End, 普通请求读取整个 body
+func (c *Context) BodyReader() io.Reader {
+	if c.stream != nil {
+		return c.stream
+	}
+	return bytes.NewReader(c.body.buf)
+}
+
+// BodyWriter 流式回包, 写入受对端窗口限制, Close 后对端读到 EOF
+func (c *Context) BodyWriter() (io.WriteCloser, error) {
+	if c.stream == nil {
+		return nil, ErrNotStream
+	}
+	return c.stream, nil
 }
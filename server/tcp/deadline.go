@@ -0,0 +1,107 @@
+package tcp
+
+import (
+	"context"
+	"github.com/ousanki/sagittarius/core/code"
+	"sync/atomic"
+	"time"
+)
+
+// HeaderTimeout 请求 header 中调用方剩余等待时长, 单位毫秒
+const HeaderTimeout = "_timeout"
+
+// ErrTimeout 处理链超过截止时间
+var ErrTimeout = code.BuildCode(-2, "handler timeout")
+
+// 回包状态
+const (
+	_stateIdle int32 = iota
+	_stateWritten
+	_stateExpired
+)
+
+// readDeadline header 携带 HeaderTimeout 时设置 Ctx 的截止时间
+func (c *Context) readDeadline() {
+	v, ok := c.header.values[HeaderTimeout]
+	if !ok {
+		return
+	}
+	ms := toInt(v)
+	if ms <= 0 {
+		return
+	}
+	c.setDeadline(time.Now().Add(time.Duration(ms) * time.Millisecond))
+}
+
+// setDeadline 仅在早于当前截止时间时生效
+func (c *Context) setDeadline(d time.Time) {
+	if cur, ok := c.ctx.Deadline(); ok && !d.Before(cur) {
+		return
+	}
+	ctx, cancel := context.WithDeadline(c.ctx, d)
+	if prev := c.cancel; prev != nil {
+		c.cancel = func() {
+			cancel()
+			prev()
+		}
+	} else {
+		c.cancel = cancel
+	}
+	c.ctx = ctx
+}
+
+// markWritten 已超时回包后不再写出
+func (c *Context) markWritten() bool {
+	if atomic.CompareAndSwapInt32(&c.state, _stateIdle, _stateWritten) {
+		return true
+	}
+	return atomic.LoadInt32(&c.state) == _stateWritten
+}
+
+// handle 执行处理链, 结束后 Context 归还 s 的对象池; 有截止时间且处理链未在截止前回包时,
+// 以 ErrTimeout 回包后立即返回, 处理链在后台继续执行并独占 Context (可通过 Ctx().Done() 感知),
+// 处理链返回后才归还, 调用方在 handle 返回后不可再使用 Context
+func (c *Context) handle(s *Engine) {
+	if _, ok := c.ctx.Deadline(); !ok {
+		c.do()
+		s.release(c)
+		return
+	}
+
+	// 处理链可能并发修改 header, 超时回包使用副本
+	values := c.replyValues()
+	id := c.header.GetID()
+	// 中间件可能在处理链中替换 Ctx
+	ctx := c.ctx
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.do()
+	}()
+	finish := func() {
+		// 入参 ctx 自带截止时间时未派生 cancel
+		if c.cancel != nil {
+			c.cancel()
+		}
+		s.release(c)
+	}
+	select {
+	case <-done:
+		finish()
+	case <-ctx.Done():
+		if atomic.CompareAndSwapInt32(&c.state, _stateIdle, _stateExpired) {
+			e := ErrTimeout.(*code.Error)
+			values[HeaderCode] = e.Code
+			values[HeaderMessage] = e.Message
+			if err := c.write(ctx, id, values, []byte("null")); err != nil {
+				genLogger.Write(ctx, "tcp route:%d timeout reply error, err:%v", id, err)
+			}
+		}
+		// 处理链结束后再归还
+		go func() {
+			<-done
+			finish()
+		}()
+	}
+}
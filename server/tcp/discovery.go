@@ -0,0 +1,89 @@
+package tcp
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+const (
+	// 注册及注销的等待时长
+	_registryTimeout = 5 * time.Second
+	// Instance.Metadata 中的协议 key
+	MetadataProto = "proto"
+)
+
+// register 开始服务时向注册中心注册, addr 为实际监听地址
+func (s *Engine) register(addr net.Addr) error {
+	if s.registry == nil {
+		return nil
+	}
+	ins := s.instance
+	if ins.Addr == "" {
+		ins.Addr = advertiseAddr(addr)
+	}
+	if ins.Weight <= 0 {
+		ins.Weight = 1
+	}
+	md := make(map[string]string, len(ins.Metadata)+1)
+	for k, v := range ins.Metadata {
+		md[k] = v
+	}
+	if _, ok := md[MetadataProto]; !ok {
+		md[MetadataProto] = s.Proto
+	}
+	ins.Metadata = md
+
+	ctx, cancel := context.WithTimeout(context.Background(), _registryTimeout)
+	defer cancel()
+	if err := s.registry.Register(ctx, ins); err != nil {
+		genLogger.Write(ctx, "tcp register error, service:%s, addr:%s, err:%v", ins.Service, ins.Addr, err)
+		return err
+	}
+	s.mu.Lock()
+	s.registered = &ins
+	s.mu.Unlock()
+	return nil
+}
+
+// deregister Stop 时先注销, 客户端不再选中本实例后再断开连接
+func (s *Engine) deregister() {
+	s.mu.Lock()
+	ins := s.registered
+	s.registered = nil
+	s.mu.Unlock()
+	if ins == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), _registryTimeout)
+	defer cancel()
+	if err := s.registry.Deregister(ctx, *ins); err != nil {
+		genLogger.Write(ctx, "tcp deregister error, service:%s, addr:%s, err:%v", ins.Service, ins.Addr, err)
+	}
+}
+
+// advertiseAddr 监听在未指定地址上时使用本机首个非回环 IPv4 地址
+func advertiseAddr(addr net.Addr) string {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = localIP()
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func localIP() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "127.0.0.1"
+	}
+	for _, a := range addrs {
+		if ipn, ok := a.(*net.IPNet); ok && !ipn.IP.IsLoopback() && ipn.IP.To4() != nil {
+			return ipn.IP.String()
+		}
+	}
+	return "127.0.0.1"
+}
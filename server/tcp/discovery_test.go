@@ -0,0 +1,44 @@
+package tcp_test
+
+import (
+	"context"
+	"github.com/ousanki/sagittarius/server/tcp"
+	"github.com/ousanki/sagittarius/server/tcp/registry"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServeDeregister(t *testing.T) {
+	ctx := context.Background()
+	reg := registry.NewMemory()
+	e := tcp.NewApp("tcp")
+	e.WithOptions(tcp.SetRegistry(reg, registry.Instance{Service: "svc"}))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- e.Serve(l)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if list, _ := reg.Lookup(ctx, "svc"); len(list) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("instance not registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 监听器异常关闭, Serve 返回时注销
+	l.Close()
+	if err = <-errc; err == nil || err == tcp.ErrServerClosed {
+		t.Fatalf("want accept error, got %v", err)
+	}
+	if list, _ := reg.Lookup(ctx, "svc"); len(list) != 0 {
+		t.Fatalf("want no instances after Serve returns, got %+v", list)
+	}
+}
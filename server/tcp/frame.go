@@ -0,0 +1,110 @@
+package tcp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Frame 解码后的一帧, 供调试及回放工具使用
+type Frame struct {
+	Version   uint8
+	Flag      uint8
+	WithTrace int8
+	ID        int64
+	// FlagStream 时有效
+	StreamID   int64
+	StreamKind uint8
+	Window     int64
+
+	Header map[string]interface{}
+	// 携带 trace 时为 SpanContext 的字符串形式, UseTraceContext 时为 traceparent
+	Trace string
+	Body  []byte
+	// 帧的原始字节, 可直接重新发送
+	Raw []byte
+}
+
+// FrameError 解码失败, Offset 为失败时本帧已读取的字节数
+type FrameError struct {
+	Offset int
+	Err    error
+}
+
+func (e *FrameError) Error() string {
+	return fmt.Sprintf("tcp: decode frame at offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *FrameError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeFrame 读取一帧, 不校验版本; UseTracer 模式的 trace 需安装与发送方一致的全局 tracer 才能正确跳过.
+// r 在帧边界结束时返回 io.EOF, 其余失败返回 *FrameError; 长度字段均经校验, 损坏的输入不会 panic
+func DecodeFrame(r io.Reader) (*Frame, error) {
+	var raw bytes.Buffer
+	h, b, spCtx, err := readFrame(io.TeeReader(r, &raw), nil, DefaultMaxFrameSize)
+	if err != nil {
+		if err == io.EOF && raw.Len() == 0 {
+			return nil, io.EOF
+		}
+		return nil, &FrameError{Offset: raw.Len(), Err: err}
+	}
+	f := &Frame{
+		Version:   h.Version,
+		Flag:      h.Flag,
+		WithTrace: h.WithTrace,
+		ID:        h.GetID(),
+		Header:    h.values,
+		Body:      b.buf,
+		Raw:       raw.Bytes(),
+	}
+	if h.HasFlag(FlagStream) {
+		f.StreamID = h.stream.StreamID
+		f.StreamKind = h.stream.Kind
+		f.Window = h.stream.Window
+	}
+	if spCtx != nil {
+		f.Trace = fmt.Sprintf("%v", spCtx)
+	}
+	if h.IsWithTraceContext() {
+		f.Trace, _ = h.values["traceparent"].(string)
+	}
+	return f, nil
+}
+
+// Flags 已设置标志位的名称
+func (f *Frame) Flags() []string {
+	var flags []string
+	if f.Flag&FlagChecksum != 0 {
+		flags = append(flags, "checksum")
+	}
+	if f.Flag&FlagStream != 0 {
+		flags = append(flags, "stream")
+	}
+	if f.Flag&FlagBinaryHeader != 0 {
+		flags = append(flags, "binary-header")
+	}
+	return flags
+}
+
+func (f *Frame) IsStream() bool {
+	return f.Flag&FlagStream != 0
+}
+
+func StreamKindName(kind uint8) string {
+	switch kind {
+	case StreamOpen:
+		return "open"
+	case StreamData:
+		return "data"
+	case StreamEnd:
+		return "end"
+	case StreamWindow:
+		return "window"
+	case StreamReset:
+		return "reset"
+	default:
+		return fmt.Sprintf("Kind(%d)", kind)
+	}
+}
@@ -0,0 +1,106 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestDecodeFrame(t *testing.T) {
+	hb := headerBase{Magic: Magic, Version: ProtoVersion, ID: 9, Flag: FlagChecksum | FlagBinaryHeader}
+	data := encodeFrame(t, hb, map[string]interface{}{"k": "v", "n": 3}, []byte(`{"a":1}`))
+	data = append(data, data...)
+
+	r := bytes.NewReader(data)
+	for i := 0; i < 2; i++ {
+		f, err := DecodeFrame(r)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if f.ID != 9 || f.Header["k"] != "v" || f.Header["n"] != float64(3) || string(f.Body) != `{"a":1}` {
+			t.Fatalf("frame %d: unexpected %+v", i, f)
+		}
+		if !bytes.Equal(f.Raw, data[:len(data)/2]) {
+			t.Fatalf("frame %d: raw bytes differ", i)
+		}
+	}
+	if _, err := DecodeFrame(r); err != io.EOF {
+		t.Fatalf("want io.EOF at end, got %v", err)
+	}
+}
+
+func TestDecodeFrameError(t *testing.T) {
+	hb := headerBase{Magic: Magic, Version: ProtoVersion, ID: 9}
+	data := encodeFrame(t, hb, map[string]interface{}{"k": "v"}, []byte(`{"a":1}`))
+
+	tests := []struct {
+		name   string
+		data   []byte
+		err    error
+		offset int
+	}{
+		{"truncated", data[:len(data)-1], io.ErrUnexpectedEOF, len(data) - 1},
+		{"misaligned", data[3:], ErrBadMagic, 21},
+		{"oversized", append(append([]byte{}, data[:5]...), 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 0, 0, 0, 1), ErrFrameTooLarge, 21},
+	}
+	for _, tt := range tests {
+		_, err := DecodeFrame(bytes.NewReader(tt.data))
+		fe, ok := err.(*FrameError)
+		if !ok {
+			t.Fatalf("%s: want *FrameError, got %v", tt.name, err)
+		}
+		if fe.Err != tt.err || fe.Offset != tt.offset {
+			t.Fatalf("%s: want %v at %d, got %v at %d", tt.name, tt.err, tt.offset, fe.Err, fe.Offset)
+		}
+	}
+}
+
+// corruptSeeds 覆盖 JSON / 紧凑 header、流、校验和及 trace context 的帧
+func corruptSeeds(t testing.TB) [][]byte {
+	var seeds [][]byte
+	for _, tc := range []struct {
+		hb headerBase
+		sb streamBase
+	}{
+		{hb: headerBase{Magic: Magic, Version: ProtoVersion, ID: 9}},
+		{hb: headerBase{Magic: Magic, Version: ProtoVersion, ID: 9, Flag: FlagBinaryHeader | FlagChecksum}},
+		{hb: headerBase{Magic: Magic, Version: ProtoVersion, ID: 9, Flag: FlagStream | FlagBinaryHeader},
+			sb: streamBase{StreamID: 3, Kind: StreamData, Window: 1024}},
+		{hb: headerBase{Magic: Magic, Version: ProtoVersion, ID: 9, WithTrace: UseTraceContext}},
+	} {
+		var buf bytes.Buffer
+		values := map[string]interface{}{"k": "v", "f": 1.5, "n": -3, "l": []int{1}}
+		if err := writeRaw(context.Background(), tc.hb, tc.sb, values, []byte(`{"a":1}`), &buf); err != nil {
+			t.Fatal(err)
+		}
+		seeds = append(seeds, buf.Bytes())
+	}
+	return seeds
+}
+
+// TestDecodeFrameCorrupted 损坏的输入只返回错误, 不 panic
+func TestDecodeFrameCorrupted(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, data := range corruptSeeds(t) {
+		for i := 0; i < 10000; i++ {
+			bs := append([]byte{}, data...)
+			// 保留魔数, 翻转帧内其余字节
+			for j := 0; j < 1+rnd.Intn(4); j++ {
+				bs[2+rnd.Intn(len(bs)-2)] ^= byte(1 + rnd.Intn(255))
+			}
+			DecodeFrame(bytes.NewReader(bs))
+			DecodeFrame(bytes.NewReader(bs[:rnd.Intn(len(bs))]))
+		}
+	}
+}
+
+func FuzzDecodeFrame(f *testing.F) {
+	for _, data := range corruptSeeds(f) {
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		DecodeFrame(bytes.NewReader(data))
+	})
+}
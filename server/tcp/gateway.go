@@ -0,0 +1,206 @@
+package tcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ousanki/sagittarius/core/code"
+	"go.opentelemetry.io/otel/propagation"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// HTTPHeaderPrefix http header 与 header values 之间的前缀, 如 X-Rpc-Uid <-> uid
+const HTTPHeaderPrefix = "X-Rpc-"
+
+// HTTPHandler 将 POST /rpc/{routeID|method} 映射到路由处理链,
+// http header 作为 header values, 请求体作为 body, 与 tcp 共用中间件
+func (s *Engine) HTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rpc/", s.serveHTTP)
+	return mux
+}
+
+// RunHTTP 在 port 上监听 http 网关
+func (s *Engine) RunHTTP(port string) error {
+	return http.ListenAndServe(fmt.Sprintf("0.0.0.0:%s", port), s.HTTPHandler())
+}
+
+type httpReply struct {
+	id     int64
+	header map[string]interface{}
+	body   []byte
+}
+
+func (s *Engine) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeHTTPError(w, http.StatusMethodNotAllowed, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	name := path.Base(r.URL.Path)
+	id, err := strconv.ParseInt(name, 10, 64)
+	if err != nil {
+		id = MethodID(name)
+	}
+	rt := s.findRoute(id)
+	if rt == nil {
+		writeHTTPError(w, http.StatusNotFound, http.StatusNotFound, "route not found")
+		return
+	}
+	if rt.stream != "" {
+		writeHTTPError(w, http.StatusBadRequest, http.StatusBadRequest, "stream route not supported")
+		return
+	}
+	// 请求体与 tcp 帧同样受 maxFrameSize 限制
+	if r.ContentLength > s.maxFrameSize {
+		writeHTTPError(w, http.StatusRequestEntityTooLarge, http.StatusRequestEntityTooLarge, ErrFrameTooLarge.Error())
+		return
+	}
+	buf, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, s.maxFrameSize))
+	if err != nil {
+		writeHTTPError(w, http.StatusBadRequest, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	values, numbers := httpHeaderValues(r.Header)
+	// 无连接, 每个请求以 header values 鉴权
+	var principal interface{}
+	if s.auth != nil {
+		principal, err = s.auth.Authenticate(r.Context(), values, nil)
+		if err != nil {
+			e, ok := code.FromError(err)
+			if !ok {
+				e = ErrUnauthenticated.(*code.Error)
+			}
+			writeHTTPError(w, http.StatusUnauthorized, e.Code, e.Message)
+			return
+		}
+	}
+
+	// http 请求可直接携带 traceparent/tracestate/baggage
+	ctx := propagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx = context.WithValue(ctx, "remote", r.RemoteAddr)
+	c := s.pool.Get().(*Context)
+	c.Build(ctx, nil)
+	c.header = &Header{
+		headerBase: headerBase{Magic: Magic, Version: ProtoVersion, ID: id},
+		values:     values,
+		numbers:    numbers,
+	}
+	c.principal = principal
+	c.body = &Body{buf: buf}
+	c.checksum = s.checksum
+	// 回包 http header 只包含处理链设置的值, 不回显请求 header
+	c.echo = false
+	c.readDeadline()
+
+	// 超时回包后处理链仍可能在后台写入
+	var (
+		mu    sync.Mutex
+		reply *httpReply
+	)
+	c.reply = func(id int64, header map[string]interface{}, body []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		if reply != nil {
+			return
+		}
+		// header values 在处理链中仍可能被修改, 复制一份
+		m := make(map[string]interface{}, len(header))
+		for k, v := range header {
+			m[k] = v
+		}
+		reply = &httpReply{id: id, header: m, body: body}
+	}
+	c.bindRoute(rt)
+	c.handle(s)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if reply == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if e := HeaderError(reply.header); e != nil {
+		writeHTTPError(w, s.httpStatus(e.Code), e.Code, e.Message)
+		return
+	}
+	for k, v := range reply.header {
+		if strings.HasPrefix(k, "_") {
+			continue
+		}
+		if str, ok := v.(string); ok {
+			w.Header().Set(HTTPHeaderPrefix+k, str)
+		} else if bs, err := json.Marshal(v); err == nil {
+			w.Header().Set(HTTPHeaderPrefix+k, string(bs))
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(reply.body)
+}
+
+// httpHeaderValues 取 X-Rpc- 前缀的 header, key 小写, 值能按 JSON 解析则解析;
+// 数字另按 json.Number 解码, 与 tcp 请求一致供 HeaderInt64 等取精确值
+func httpHeaderValues(h http.Header) (map[string]interface{}, map[string]interface{}) {
+	values := make(map[string]interface{})
+	numbers := make(map[string]interface{})
+	for k, vs := range h {
+		if len(vs) == 0 || len(k) <= len(HTTPHeaderPrefix) ||
+			!strings.EqualFold(k[:len(HTTPHeaderPrefix)], HTTPHeaderPrefix) {
+			continue
+		}
+		key := strings.ToLower(k[len(HTTPHeaderPrefix):])
+		var v interface{}
+		if err := json.Unmarshal([]byte(vs[0]), &v); err != nil {
+			v = vs[0]
+		}
+		if _, ok := v.(float64); ok {
+			var n interface{}
+			if UnmarshalUseNumber([]byte(vs[0]), &n) == nil {
+				numbers[key] = n
+			}
+		}
+		values[key] = v
+	}
+	return values, numbers
+}
+
+func writeHTTPError(w http.ResponseWriter, status int, c int, msg string) {
+	bs, _ := json.Marshal(map[string]interface{}{"code": c, "msg": msg})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(bs)
+}
+
+func (s *Engine) httpStatus(c int) int {
+	if s.httpStatusFn != nil {
+		return s.httpStatusFn(c)
+	}
+	return DefaultHTTPStatus(c)
+}
+
+// DefaultHTTPStatus 400~599 的错误码直接作为 http 状态, ErrInternal 为 500, ErrTimeout 为 504,
+// ErrForbidden 为 403, ErrNotFound 为 404, ErrUnauthenticated 为 401, 其余为 400
+func DefaultHTTPStatus(c int) int {
+	switch {
+	case c >= 400 && c < 600:
+		return c
+	case c == ErrInternal.(*code.Error).Code:
+		return http.StatusInternalServerError
+	case c == ErrTimeout.(*code.Error).Code:
+		return http.StatusGatewayTimeout
+	case c == ErrForbidden.(*code.Error).Code:
+		return http.StatusForbidden
+	case c == ErrNotFound.(*code.Error).Code:
+		return http.StatusNotFound
+	case c == ErrUnauthenticated.(*code.Error).Code:
+		return http.StatusUnauthorized
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,115 @@
+package tcp_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ousanki/sagittarius/server/tcp"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGatewayHeader(t *testing.T) {
+	e := tcp.NewApp("tcp")
+	e.HandleNamed("svc.Add", func(c *tcp.Context, req addReq) (addResp, error) {
+		c.SetResponseHeader("region", "cn")
+		return add(c, req)
+	})
+	srv := httptest.NewServer(e.HTTPHandler())
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/rpc/svc.Add", strings.NewReader(`{"a":1}`))
+	req.Header.Set("X-Rpc-Token", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want 200, got %d", resp.StatusCode)
+	}
+	// 请求 header 不回显
+	if v := resp.Header.Get("X-Rpc-Token"); v != "" {
+		t.Fatalf("request header echoed: %q", v)
+	}
+	if v := resp.Header.Get("X-Rpc-Region"); v != "cn" {
+		t.Fatalf("want response header cn, got %q", v)
+	}
+}
+
+func TestGatewayBodyLimit(t *testing.T) {
+	e := tcp.NewApp("tcp")
+	e.WithOptions(tcp.SetMaxFrameSize(16))
+	e.HandleNamed("svc.Add", add)
+	srv := httptest.NewServer(e.HTTPHandler())
+	defer srv.Close()
+
+	body := bytes.Repeat([]byte(" "), 32)
+	resp, err := http.Post(srv.URL+"/rpc/svc.Add", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("want 413, got %d", resp.StatusCode)
+	}
+
+	// 未声明长度的请求体在读取时截断
+	pr := struct{ *bytes.Reader }{bytes.NewReader(body)}
+	resp, err = http.Post(srv.URL+"/rpc/svc.Add", "application/json", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("want 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestGatewayParentDeadline(t *testing.T) {
+	e := tcp.NewApp("tcp")
+	e.HandleNamed("svc.Add", add)
+	// TimeoutHandler 使每个请求的 ctx 自带截止时间
+	srv := httptest.NewServer(http.TimeoutHandler(e.HTTPHandler(), time.Second, "timeout"))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/rpc/svc.Add", "application/json", strings.NewReader(`{"a":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want 200, got %d", resp.StatusCode)
+	}
+	var r addResp
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	if r.B != 2 {
+		t.Fatalf("want 2, got %d", r.B)
+	}
+}
+
+func TestGatewayHeaderLargeInt(t *testing.T) {
+	e := tcp.NewApp("tcp")
+	e.HandleNamed("svc.Uid", func(c *tcp.Context, req addReq) (addResp, error) {
+		c.SetResponseHeader("uid", strconv.FormatInt(c.HeaderInt64("uid"), 10))
+		return addResp{}, nil
+	})
+	srv := httptest.NewServer(e.HTTPHandler())
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/rpc/svc.Uid", strings.NewReader(`{}`))
+	req.Header.Set("X-Rpc-Uid", "9007199254740993")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if v := resp.Header.Get("X-Rpc-Uid"); v != "9007199254740993" {
+		t.Fatalf("want exact uid, got %q", v)
+	}
+}
@@ -0,0 +1,143 @@
+package tcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ousanki/sagittarius/core/code"
+	"reflect"
+)
+
+// 回包错误码写入回包 header
+const (
+	HeaderCode    = "_code"
+	HeaderMessage = "_msg"
+)
+
+// HeaderError 回包 header 中携带的错误码, 没有时为 nil
+func HeaderError(header map[string]interface{}) *code.Error {
+	v, ok := header[HeaderCode]
+	if !ok {
+		return nil
+	}
+	msg, _ := header[HeaderMessage].(string)
+	return &code.Error{Code: toInt(v), Message: msg}
+}
+
+func toInt(v interface{}) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	case json.Number:
+		i, _ := n.Int64()
+		return int(i)
+	}
+	return 0
+}
+
+// ErrInternal 非 code.Error 的错误统一返回
+var ErrInternal = code.BuildCode(-1, "internal error")
+
+// ErrBadRequest 请求与路由不匹配或参数错误
+var ErrBadRequest = code.BuildCode(-5, "bad request")
+
+// ErrNotFound 请求的路由未注册
+var ErrNotFound = code.BuildCode(-6, "route not found")
+
+// _notFound 未注册路由的处理链, 回包 ErrNotFound 避免调用方一直等待
+var _notFound = []core{func(c *Context) {
+	genLogger.Write(c.Ctx(), "tcp route:%d not found, remote:%v", c.ID(), c.Ctx().Value("remote"))
+	c.WriteError(c.ID(), ErrNotFound)
+}}
+
+var (
+	_contextType = reflect.TypeOf((*Context)(nil))
+	_errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// WriteError 以 header 携带错误码回包, body 为空
+func (c *Context) WriteError(id int64, err error) error {
+	e, ok := code.FromError(err)
+	if !ok {
+		e = ErrInternal.(*code.Error)
+	}
+	c.SetResponseHeader(HeaderCode, e.Code)
+	c.SetResponseHeader(HeaderMessage, e.Message)
+	return c.Write(id, nil)
+}
+
+// Handle 注册强类型处理函数 func(*Context, Req) (Resp, error),
+// body 按 JSON 解码为 Req, 返回值以同一 id 回包
+func (g *Group) Handle(id int64, handler interface{}) {
+	g.handle(id, "", handler)
+}
+
+// HandleNamed 以方法名注册强类型处理函数, 返回对应的路由 ID
+func (g *Group) HandleNamed(method string, handler interface{}) int64 {
+	id := MethodID(method)
+	g.handle(id, method, handler)
+	return id
+}
+
+// ReplaceHandle 运行时替换为强类型处理函数, 不存在时新增; 未指定方法名时沿用原路由的方法名
+func (g *Group) ReplaceHandle(id int64, handler interface{}) {
+	g.svr.replaceCore(g.build(typedRoute(id, "", handler)))
+}
+
+// ReplaceHandleNamed 以方法名替换强类型处理函数, 返回对应的路由 ID
+func (g *Group) ReplaceHandleNamed(method string, handler interface{}) int64 {
+	id := MethodID(method)
+	g.svr.replaceCore(g.build(typedRoute(id, method, handler)))
+	return id
+}
+
+func (g *Group) handle(id int64, method string, handler interface{}) {
+	g.add(typedRoute(id, method, handler))
+}
+
+// typedRoute 校验强类型处理函数, 返回路由及其处理函数
+func typedRoute(id int64, method string, handler interface{}) (*route, core) {
+	fn := reflect.ValueOf(handler)
+	ft := fn.Type()
+	if ft.Kind() != reflect.Func ||
+		ft.NumIn() != 2 || ft.In(0) != _contextType ||
+		ft.NumOut() != 2 || ft.Out(1) != _errorType {
+		panic(fmt.Sprintf("server router id:%d handler must be func(*tcp.Context, Req) (Resp, error), got %s", id, ft))
+	}
+	reqType := ft.In(1)
+
+	r := &route{
+		id:       id,
+		name:     funcName(handler),
+		method:   method,
+		request:  reqType,
+		response: ft.Out(0),
+	}
+	return r, func(c *Context) {
+		var req reflect.Value
+		if reqType.Kind() == reflect.Ptr {
+			req = reflect.New(reqType.Elem())
+		} else {
+			req = reflect.New(reqType)
+		}
+		if len(c.body.buf) > 0 {
+			if err := c.ReadJSON(req.Interface()); err != nil {
+				genLogger.Write(c.Ctx(), "tcp route:%d decode request error:%v", id, err)
+				c.WriteError(id, err)
+				return
+			}
+		}
+		if reqType.Kind() != reflect.Ptr {
+			req = req.Elem()
+		}
+		out := fn.Call([]reflect.Value{reflect.ValueOf(c), req})
+		if err, _ := out[1].Interface().(error); err != nil {
+			c.WriteError(id, err)
+			return
+		}
+		c.Write(id, out[0].Interface())
+	}
+}
@@ -0,0 +1,185 @@
+package tcp_test
+
+import (
+	"github.com/ousanki/sagittarius/core/code"
+	"github.com/ousanki/sagittarius/server/tcp"
+	"github.com/ousanki/sagittarius/server/tcp/tcptest"
+	"testing"
+	"time"
+)
+
+func TestHandle(t *testing.T) {
+	e := tcp.NewApp("tcp")
+	id := e.HandleNamed("svc.Add", add)
+	e.Handle(2, func(c *tcp.Context, req *addReq) (*addResp, error) {
+		return nil, code.BuildCode(1001, "bad a")
+	})
+	srv := tcptest.NewServer(e)
+	defer srv.Close()
+
+	var resp addResp
+	if err := srv.Call(id, addReq{A: 1}, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.B != 2 {
+		t.Fatalf("want 2, got %d", resp.B)
+	}
+	tcptest.AssertCode(t, srv.Call(2, addReq{}, nil), 1001)
+}
+
+func TestRouteNotFound(t *testing.T) {
+	srv := tcptest.NewServer(tcp.NewApp("tcp"))
+	defer srv.Close()
+
+	tcptest.AssertCode(t, srv.Call(404, addReq{}, nil), -6)
+}
+
+func routeInfo(e *tcp.Engine, id int64) tcp.RouteInfo {
+	for _, r := range e.Routes() {
+		if r.ID == id {
+			return r
+		}
+	}
+	return tcp.RouteInfo{}
+}
+
+func TestGroupName(t *testing.T) {
+	e := tcp.NewApp("tcp")
+	g := e.Name("svc")
+	sub := g.Name("v2")
+	a := g.HandleNamed("svc.A", add)
+	b := sub.HandleNamed("svc.B", add)
+	c := e.HandleNamed("svc.C", add)
+
+	for id, want := range map[int64]string{a: "svc", b: "svc.v2", c: ""} {
+		if got := routeInfo(e, id).Group; got != want {
+			t.Fatalf("route %d: want group %q, got %q", id, want, got)
+		}
+	}
+}
+
+func TestReplace(t *testing.T) {
+	e := tcp.NewApp("tcp")
+	id := e.HandleNamed("svc.Add", add)
+	srv := tcptest.NewServer(e)
+	defer srv.Close()
+
+	// 原始处理链替换保留方法名及类型
+	e.Replace(id, func(c *tcp.Context) {
+		c.Write(c.ID(), addResp{B: -1})
+	})
+	ri := routeInfo(e, id)
+	if ri.Method != "svc.Add" || ri.Request == "" || ri.Response == "" {
+		t.Fatalf("metadata dropped: %+v", ri)
+	}
+	var resp addResp
+	if err := srv.Call(id, addReq{A: 1}, &resp); err != nil || resp.B != -1 {
+		t.Fatalf("want replaced handler, got %v %v", resp, err)
+	}
+
+	e.ReplaceHandle(id, func(c *tcp.Context, req *addReq) (*addResp, error) {
+		return &addResp{B: req.A * 10}, nil
+	})
+	if ri = routeInfo(e, id); ri.Method != "svc.Add" || ri.Request != "*tcp_test.addReq" {
+		t.Fatalf("want typed metadata, got %+v", ri)
+	}
+	if err := srv.Call(id, addReq{A: 2}, &resp); err != nil || resp.B != 20 {
+		t.Fatalf("want 20, got %v %v", resp, err)
+	}
+
+	e.ReplaceStream(id, tcp.BidiStreaming, func(s *tcp.Stream) error {
+		return nil
+	})
+	if ri = routeInfo(e, id); ri.Stream != tcp.BidiStreaming.String() || ri.Request != "" {
+		t.Fatalf("want stream metadata, got %+v", ri)
+	}
+}
+
+// TestHandlerTimeout go test -race, 超时回包后处理链继续持有 Context, 返回后才归还对象池
+func TestHandlerTimeout(t *testing.T) {
+	e := tcp.NewApp("tcp")
+	release := make(chan struct{})
+	finished := make(chan int, 1)
+	slow := e.Timeout(20*time.Millisecond).HandleNamed("svc.Slow", func(c *tcp.Context, req addReq) (addResp, error) {
+		<-c.Ctx().Done()
+		<-release
+		// 超时后 Context 仍可读
+		finished <- req.A
+		return addResp{B: req.A}, nil
+	})
+	fast := e.HandleNamed("svc.Fast", add)
+	// Timeout 不影响之后在根分组注册的路由
+	if ri := routeInfo(e, fast); ri.Timeout != "" {
+		t.Fatalf("want no timeout on svc.Fast, got %q", ri.Timeout)
+	}
+	srv := tcptest.NewServer(e)
+	defer srv.Close()
+
+	tcptest.AssertCode(t, srv.Call(slow, addReq{A: 7}, nil), -2)
+	// 后续请求复用对象池, 不影响仍在执行的处理链
+	for i := 0; i < 10; i++ {
+		var resp addResp
+		if err := srv.Call(fast, addReq{A: i}, &resp); err != nil || resp.B != i+1 {
+			t.Fatalf("call %d: want %d, got %v %v", i, i+1, resp.B, err)
+		}
+	}
+	close(release)
+	if a := <-finished; a != 7 {
+		t.Fatalf("want request 7 in the expired handler, got %d", a)
+	}
+}
+
+func TestInvokeNamed(t *testing.T) {
+	e := tcp.NewApp("tcp")
+	var seen []string
+	e.Use(func(c *tcp.Context) {
+		// 中间件同样可读取方法名
+		seen = append(seen, c.Method())
+		c.Next()
+	})
+	method := func(c *tcp.Context) {
+		c.Write(c.ID(), c.Method())
+	}
+	id := e.InvokeNamed("svc.Echo", method)
+	if id != tcp.MethodID("svc.Echo") {
+		t.Fatalf("want MethodID, got %d", id)
+	}
+	e.Invoke(1, method)
+	srv := tcptest.NewServer(e)
+	defer srv.Close()
+
+	var name string
+	if err := srv.Call(id, nil, &name); err != nil || name != "svc.Echo" {
+		t.Fatalf("want svc.Echo, got %q %v", name, err)
+	}
+	// 数字 ID 注册的路由没有方法名
+	if err := srv.Call(1, nil, &name); err != nil || name != "" {
+		t.Fatalf("want empty method, got %q %v", name, err)
+	}
+	if len(seen) != 2 || seen[0] != "svc.Echo" || seen[1] != "" {
+		t.Fatalf("middleware saw %q", seen)
+	}
+}
+
+func TestInvokeNamedCollision(t *testing.T) {
+	id := tcp.MethodID("svc.Echo")
+	for name, register := range map[string]func(e *tcp.Engine){
+		"named after numeric": func(e *tcp.Engine) {
+			e.Invoke(id, func(c *tcp.Context) {})
+			e.InvokeNamed("svc.Echo", func(c *tcp.Context) {})
+		},
+		"numeric after named": func(e *tcp.Engine) {
+			e.InvokeNamed("svc.Echo", func(c *tcp.Context) {})
+			e.Invoke(id, func(c *tcp.Context) {})
+		},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("%s: want panic for colliding id %d", name, id)
+				}
+			}()
+			register(tcp.NewApp("tcp"))
+		}()
+	}
+}
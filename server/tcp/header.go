@@ -0,0 +1,240 @@
+package tcp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrBindTarget = errors.New("tcp: BindHeader requires a non-nil struct pointer")
+	_timeType     = reflect.TypeOf(time.Time{})
+)
+
+// HeaderString 请求 header 值转换为字符串, 不存在或无法转换时为空
+func (c *Context) HeaderString(key string) string {
+	s, _ := headerString(c.headerValue(key))
+	return s
+}
+
+// HeaderInt64 请求 header 值转换为整数, 支持数字及数字字符串
+func (c *Context) HeaderInt64(key string) int64 {
+	n, _ := headerInt64(c.headerValue(key))
+	return n
+}
+
+// HeaderBool 请求 header 值转换为布尔值, 支持 bool、"true"/"1" 及数字
+func (c *Context) HeaderBool(key string) bool {
+	b, _ := headerBool(c.headerValue(key))
+	return b
+}
+
+// HeaderTime 请求 header 值转换为时间, 字符串为 RFC3339, 数字为 unix 秒
+func (c *Context) HeaderTime(key string) time.Time {
+	t, _ := headerTime(c.headerValue(key))
+	return t
+}
+
+// BindHeader 按字段 tag `header:"uid"` 将请求 header 值绑定到结构体, 不存在的 key 保持原值
+func (c *Context) BindHeader(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return ErrBindTarget
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		key := f.Tag.Get("header")
+		if key == "" || key == "-" || f.PkgPath != "" {
+			continue
+		}
+		hv := c.headerValue(key)
+		if hv == nil {
+			continue
+		}
+		if err := setHeaderField(rv.Field(i), hv); err != nil {
+			return fmt.Errorf("tcp: bind header %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// headerValue 数字类型的请求 header 取精确值, 大于 2^53 的整数不丢失精度, 其余同 GetHeaderValue
+func (c *Context) headerValue(key string) interface{} {
+	v := c.GetHeaderValue(key)
+	if f, ok := v.(float64); ok {
+		if n, ok := c.header.number(key, f); ok {
+			return n
+		}
+	}
+	return v
+}
+
+// SetResponseHeader 设置回包 header, 不修改请求 header
+func (c *Context) SetResponseHeader(key string, value interface{}) {
+	if c.respHeader == nil {
+		c.respHeader = make(map[string]interface{})
+	}
+	c.respHeader[key] = value
+}
+
+// ResponseHeader 已设置的回包 header
+func (c *Context) ResponseHeader() map[string]interface{} {
+	return c.respHeader
+}
+
+// replyValues 回包 header: 回显的请求 header 之上覆盖回包 header;
+// 关闭回显时只保留 "_" 开头的保留 key (如 HeaderSeq)
+func (c *Context) replyValues() map[string]interface{} {
+	values := make(map[string]interface{}, len(c.header.values)+len(c.respHeader))
+	for k, v := range c.header.values {
+		if c.echo || strings.HasPrefix(k, "_") {
+			values[k] = v
+		}
+	}
+	for k, v := range c.respHeader {
+		values[k] = v
+	}
+	return values
+}
+
+func setHeaderField(fv reflect.Value, hv interface{}) error {
+	if fv.Type() == _timeType {
+		t, ok := headerTime(hv)
+		if !ok {
+			return fmt.Errorf("cannot convert %v to time", hv)
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	}
+	var ok bool
+	switch fv.Kind() {
+	case reflect.String:
+		var s string
+		if s, ok = headerString(hv); ok {
+			fv.SetString(s)
+		}
+	case reflect.Bool:
+		var b bool
+		if b, ok = headerBool(hv); ok {
+			fv.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		if n, ok = headerInt64(hv); ok && !fv.OverflowInt(n) {
+			fv.SetInt(n)
+		} else {
+			ok = false
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n int64
+		if n, ok = headerInt64(hv); ok && n >= 0 && !fv.OverflowUint(uint64(n)) {
+			fv.SetUint(uint64(n))
+		} else {
+			ok = false
+		}
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		if f, ok = headerFloat64(hv); ok {
+			fv.SetFloat(f)
+		}
+	default:
+		// 其余类型按 JSON 转换
+		bs, err := json.Marshal(hv)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(bs, fv.Addr().Interface())
+	}
+	if !ok {
+		return fmt.Errorf("cannot convert %v to %s", hv, fv.Type())
+	}
+	return nil
+}
+
+func headerString(v interface{}) (string, bool) {
+	switch x := v.(type) {
+	case string:
+		return x, true
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(x), true
+	case int, int64, uint64, json.Number:
+		return fmt.Sprint(x), true
+	}
+	return "", false
+}
+
+func headerFloat64(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case int:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case uint64:
+		return float64(x), true
+	case json.Number:
+		f, err := x.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func headerInt64(v interface{}) (int64, bool) {
+	switch x := v.(type) {
+	case int:
+		return int64(x), true
+	case int64:
+		return x, true
+	case json.Number:
+		if n, err := x.Int64(); err == nil {
+			return n, true
+		}
+	case string:
+		if n, err := strconv.ParseInt(strings.TrimSpace(x), 10, 64); err == nil {
+			return n, true
+		}
+	}
+	f, ok := headerFloat64(v)
+	if !ok || f != float64(int64(f)) {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+func headerBool(v interface{}) (bool, bool) {
+	switch x := v.(type) {
+	case bool:
+		return x, true
+	case string:
+		b, err := strconv.ParseBool(strings.TrimSpace(x))
+		return b, err == nil
+	}
+	f, ok := headerFloat64(v)
+	return f != 0, ok
+}
+
+func headerTime(v interface{}) (time.Time, bool) {
+	if s, ok := v.(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t, true
+		}
+	}
+	f, ok := headerFloat64(v)
+	if !ok {
+		return time.Time{}, false
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9)), true
+}
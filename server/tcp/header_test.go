@@ -0,0 +1,82 @@
+package tcp_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/ousanki/sagittarius/server/tcp"
+	"github.com/ousanki/sagittarius/server/tcp/tcptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHeaderLargeInt(t *testing.T) {
+	const uid = int64(1<<53 + 1)
+	e := tcp.NewApp("tcp")
+	e.Invoke(1, func(c *tcp.Context) {
+		var h struct {
+			UID  int64  `header:"uid"`
+			Lang string `header:"lang"`
+		}
+		if err := c.BindHeader(&h); err != nil {
+			c.WriteError(1, err)
+			return
+		}
+		// GetHeaderValue 与编码无关, 数字均为 float64
+		if _, ok := c.GetHeaderValue("uid").(float64); !ok {
+			c.WriteError(1, fmt.Errorf("want float64, got %T", c.GetHeaderValue("uid")))
+			return
+		}
+		// 回包 header 的数字同样为 float64, 以字符串回传精确值
+		c.SetResponseHeader("uid", strconv.FormatInt(c.HeaderInt64("uid"), 10))
+		c.SetResponseHeader("bound", strconv.FormatInt(h.UID, 10))
+		c.Write(1, nil)
+	})
+	for _, binary := range []bool{false, true} {
+		srv := tcptest.NewServer(e, tcptest.SetClientOptions(tcp.SetClientBinaryHeader(binary)))
+		r, err := srv.Send(1, map[string]interface{}{"uid": uid, "lang": "zh"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = r.Err(); err != nil {
+			t.Fatal(err)
+		}
+		tcptest.AssertHeader(t, r, "uid", strconv.FormatInt(uid, 10))
+		tcptest.AssertHeader(t, r, "bound", strconv.FormatInt(uid, 10))
+		srv.Close()
+	}
+}
+
+func TestEchoHeaderDisabled(t *testing.T) {
+	e := tcp.NewApp("tcp")
+	e.WithOptions(
+		tcp.SetEchoHeader(false),
+		tcp.SetAuthenticator(tcp.AuthenticatorFunc(func(ctx context.Context, header map[string]interface{}, body []byte) (interface{}, error) {
+			return "user", nil
+		}), time.Second),
+	)
+	e.Invoke(1, func(c *tcp.Context) {
+		c.Write(1, nil)
+	})
+	srv := tcptest.NewServer(e)
+	defer srv.Close()
+
+	// 鉴权回包同样不回显请求 header
+	r, err := srv.Send(tcp.AuthID, map[string]interface{}{"token": "secret"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.Header["token"]; ok {
+		t.Fatalf("auth reply echoes request header: %v", r.Header)
+	}
+	r, err = srv.Send(1, map[string]interface{}{"token": "secret"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.Header["token"]; ok {
+		t.Fatalf("reply echoes request header: %v", r.Header)
+	}
+	if _, ok := r.Header[tcp.HeaderSeq]; !ok {
+		t.Fatalf("reply misses %s: %v", tcp.HeaderSeq, r.Header)
+	}
+}
@@ -0,0 +1,25 @@
+package tcp
+
+import (
+	"context"
+)
+
+// PingID 心跳的路由 ID, Engine 内置处理, 以空 body 回包; 连接鉴权前也可发送
+const PingID int64 = -2
+
+var _pingRoute = &route{
+	id:   PingID,
+	name: "ping",
+	cores: []core{func(c *Context) {
+		c.Write(PingID, nil)
+	}},
+}
+
+// Ping 发送心跳并等待回包
+func (cl *Client) Ping(ctx context.Context) error {
+	r, err := cl.Send(ctx, PingID, nil, nil)
+	if err != nil {
+		return err
+	}
+	return r.Err()
+}
@@ -5,23 +5,73 @@ import (
 	"context"
 	"encoding/binary"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"github.com/opentracing/opentracing-go"
+	"hash"
+	"hash/crc32"
 	"io"
 	"net"
+	"sync/atomic"
 )
 
 const (
 	UseTracer   = 1
 	UnUseTracer = 0
+	// trace 以 W3C traceparent/tracestate 及 baggage 写入 header values,
+	// UseTracer 为 opentracing Binary 字节, 需两端安装一致的 tracer
+	UseTraceContext = 2
 )
 
+// 帧头魔数 "SG"
+const Magic uint16 = 0x5347
+
+const (
+	ProtoVersion1 uint8 = 1
+	// 当前版本
+	ProtoVersion = ProtoVersion1
+)
+
+const (
+	// 帧尾附带 header+body 的 CRC32
+	FlagChecksum uint8 = 1 << iota
+	// headerBase 之后紧跟 streamBase
+	FlagStream
+	// header 使用紧凑二进制编码, 否则为 JSON
+	FlagBinaryHeader
+)
+
+// DefaultMaxFrameSize 单帧 header 与 body 的长度上限
+const DefaultMaxFrameSize int64 = 16 << 20
+
+var (
+	ErrBadMagic           = errors.New("tcp: bad frame magic")
+	ErrUnsupportedVersion = errors.New("tcp: unsupported protocol version")
+	ErrChecksum           = errors.New("tcp: frame checksum mismatch")
+	ErrFrameTooLarge      = errors.New("tcp: frame too large")
+)
+
+// IsProtocolError 协议层错误, 连接上的后续字节已不可信
+func IsProtocolError(err error) bool {
+	return err == ErrBadMagic || err == ErrUnsupportedVersion || err == ErrChecksum || err == ErrFrameTooLarge
+}
+
 type headerBase struct {
+	Magic     uint16
+	Version   uint8
+	Flag      uint8
 	WithTrace int8
 	Len       int64
 	ID        int64
 }
 
+func (hb *headerBase) GetVersion() uint8 {
+	return hb.Version
+}
+
+func (hb *headerBase) HasFlag(flag uint8) bool {
+	return hb.Flag&flag == flag
+}
+
 func (hb *headerBase) GetID() int64 {
 	return hb.ID
 }
@@ -30,10 +80,35 @@ func (hb *headerBase) IsWithTrace() bool {
 	return hb.WithTrace == UseTracer
 }
 
+func (hb *headerBase) IsWithTraceContext() bool {
+	return hb.WithTrace == UseTraceContext
+}
+
 type Header struct {
 	headerBase
+	stream streamBase
 	buf    []byte
 	values map[string]interface{}
+	// 数字解码为 json.Number 的 header, 首次按类型读取时解码
+	numbers map[string]interface{}
+}
+
+// number 取 key 对应的精确整数, 值已被修改 (与 f 不一致) 时不使用
+func (h *Header) number(key string, f float64) (json.Number, bool) {
+	if h.numbers == nil {
+		var err error
+		if h.numbers, err = decodeNumbers(h.Flag, h.buf); err != nil {
+			h.numbers = make(map[string]interface{})
+		}
+	}
+	n, ok := h.numbers[key].(json.Number)
+	if !ok {
+		return "", false
+	}
+	if v, err := n.Float64(); err != nil || v != f {
+		return "", false
+	}
+	return n, true
 }
 
 type bodyBase struct {
@@ -45,48 +120,127 @@ type Body struct {
 	buf []byte
 }
 
+// frameReader 按需累计已读字节的 CRC32
+type frameReader struct {
+	r   io.Reader
+	crc hash.Hash32
+}
+
+func (fr *frameReader) Read(p []byte) (int, error) {
+	n, err := fr.r.Read(p)
+	if fr.crc != nil && n > 0 {
+		fr.crc.Write(p[:n])
+	}
+	return n, err
+}
+
+func (fr *frameReader) verify() error {
+	var sum uint32
+	err := binary.Read(fr.r, binary.BigEndian, &sum)
+	if err != nil {
+		return err
+	}
+	if sum != fr.crc.Sum32() {
+		return ErrChecksum
+	}
+	return nil
+}
+
 func Read(ctx context.Context, conn *conn) (*Context, error) {
 	c := conn.server.pool.Get().(*Context)
 	c.Build(ctx, conn.c)
-	// read header
-	h, err := readHeader(c.conn)
+	c.session = conn
+	c.principal = conn.getPrincipal()
+	// 鉴权、流及普通请求的回包均按 Engine 设置
+	c.checksum = conn.server.checksum
+	c.echo = conn.server.echoHeader
+	h, b, spCtx, err := readFrame(c.conn, conn.server.versions, conn.server.maxFrameSize)
 	if err != nil {
 		return nil, err
 	}
+	atomic.AddUint64(&conn.frames, 1)
+	if h.HasFlag(FlagBinaryHeader) {
+		atomic.StoreInt32(&conn.binaryHeader, 1)
+	}
 	c.header = h
+	c.body = b
+	// tracer, 服务端 span 由 tracing 中间件创建
+	if spCtx != nil {
+		c.ctx = withRemoteSpanContext(c.ctx, spCtx)
+	}
+	if h.IsWithTraceContext() {
+		c.ctx = extractTrace(c.ctx, h.values)
+	}
+	c.readDeadline()
+	return c, nil
+}
+
+// readFrame 读取一帧, 携带 trace 时返回对端 SpanContext;
+// header 与 body 的长度为负或合计超过 max 时返回 ErrFrameTooLarge
+func readFrame(r io.Reader, versions map[uint8]struct{}, max int64) (*Header, *Body, opentracing.SpanContext, error) {
+	fr := &frameReader{r: r}
+	// read header
+	h, err := readHeader(fr, versions, max)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 	// tracer
+	var spCtx opentracing.SpanContext
 	if h.IsWithTrace() {
-		spCtx, err := opentracing.GlobalTracer().Extract(
+		spCtx, err = opentracing.GlobalTracer().Extract(
 			opentracing.Binary,
-			c.conn,
+			fr,
 		)
 		if err != nil {
-			return nil, err
-		} else {
-			// reset ctx
-			span := opentracing.GlobalTracer().StartSpan(fmt.Sprintf("%d", h.GetID()), opentracing.ChildOf(spCtx))
-			c.ctx = opentracing.ContextWithSpan(c.ctx, span)
+			return nil, nil, nil, err
 		}
 	}
 	// read body
-	b, err := readBody(c.conn)
+	b, err := readBody(fr, max-h.Len)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
-	c.body = b
-	return c, nil
+	// checksum
+	if h.HasFlag(FlagChecksum) {
+		if err = fr.verify(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return h, b, spCtx, nil
 }
 
-func readHeader(r io.Reader) (*Header, error) {
+func readHeader(fr *frameReader, versions map[uint8]struct{}, max int64) (*Header, error) {
 	// get header len
 	h := new(Header)
-	err := binary.Read(r, binary.BigEndian, &h.headerBase)
+	err := binary.Read(fr, binary.BigEndian, &h.headerBase)
 	if err != nil {
 		return nil, err
 	}
+	// check magic & version
+	if h.Magic != Magic {
+		return nil, ErrBadMagic
+	}
+	// versions 为 nil 时接受任意版本
+	if _, ok := versions[h.Version]; !ok && versions != nil {
+		return nil, ErrUnsupportedVersion
+	}
+	if h.Len < 0 || h.Len > max {
+		return nil, ErrFrameTooLarge
+	}
+	if h.HasFlag(FlagChecksum) {
+		fr.crc = crc32.NewIEEE()
+		binary.Write(fr.crc, binary.BigEndian, h.headerBase)
+	}
+	// get stream
+	if h.HasFlag(FlagStream) {
+		err = binary.Read(fr, binary.BigEndian, &h.stream)
+		if err != nil {
+			return nil, err
+		}
+	}
 	// get header buff
 	h.buf = make([]byte, h.Len)
-	_, err = io.ReadFull(r, h.buf)
+	_, err = io.ReadFull(fr, h.buf)
 	if err != nil {
 		return nil, err
 	}
@@ -94,20 +248,23 @@ func readHeader(r io.Reader) (*Header, error) {
 	if h.values == nil {
 		h.values = make(map[string]interface{})
 	}
-	err = json.Unmarshal(h.buf, &h.values)
+	err = decodeHeader(h.Flag, h.buf, h.values)
 	if err != nil {
 		return nil, err
 	}
 	return h, nil
 }
 
-func readBody(r io.Reader) (*Body, error) {
+func readBody(r io.Reader, max int64) (*Body, error) {
 	// get header len
 	b := Body{}
 	err := binary.Read(r, binary.BigEndian, &b.bodyBase)
 	if err != nil {
 		return nil, err
 	}
+	if b.Len < 0 || b.Len > max {
+		return nil, ErrFrameTooLarge
+	}
 	// get header buff
 	b.buf = make([]byte, b.Len)
 	_, err = io.ReadFull(r, b.buf)
@@ -117,6 +274,8 @@ func readBody(r io.Reader) (*Body, error) {
 	return &b, nil
 }
 
+// Write 向 conn 写入一帧, 同一连接上的并发写入需由调用方串行;
+// 服务端在处理函数之外推送请使用 Session.Write
 func Write(
 	ctx context.Context,
 	withTrace int8,
@@ -125,10 +284,48 @@ func Write(
 	data interface{},
 	conn net.Conn) error {
 	hb := headerBase{
+		Magic:     Magic,
+		Version:   ProtoVersion,
 		ID:        id,
 		WithTrace: withTrace,
 	}
-	hv, err := json.Marshal(headerValues)
+	return writeFrame(ctx, hb, headerValues, data, conn)
+}
+
+func writeFrame(
+	ctx context.Context,
+	hb headerBase,
+	headerValues map[string]interface{},
+	data interface{},
+	conn net.Conn) error {
+	bv, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return writeRaw(ctx, hb, streamBase{}, headerValues, bv, conn)
+}
+
+// writeRaw body 不做编码直接写入
+func writeRaw(
+	ctx context.Context,
+	hb headerBase,
+	sb streamBase,
+	headerValues map[string]interface{},
+	bv []byte,
+	conn io.Writer) error {
+	if hb.IsWithTraceContext() {
+		headerValues = injectTrace(ctx, headerValues)
+	}
+	// ctx 中没有 span 时不写入 trace, 帧头同时清除标记
+	var sc opentracing.SpanContext
+	if hb.IsWithTrace() {
+		if span := opentracing.SpanFromContext(ctx); span != nil {
+			sc = span.Context()
+		} else if sc = RemoteSpanContext(ctx); sc == nil {
+			hb.WithTrace = UnUseTracer
+		}
+	}
+	hv, err := encodeHeader(hb.Flag, headerValues)
 	if err != nil {
 		return err
 	}
@@ -139,18 +336,20 @@ func Write(
 	if err != nil {
 		return err
 	}
+	if hb.HasFlag(FlagStream) {
+		err = binary.Write(buf, binary.BigEndian, sb)
+		if err != nil {
+			return err
+		}
+	}
 	err = binary.Write(buf, binary.BigEndian, hv)
 	if err != nil {
 		return err
 	}
 	// write span
 	if hb.IsWithTrace() {
-		span := opentracing.SpanFromContext(ctx)
-		if span == nil {
-			span = opentracing.StartSpan(fmt.Sprintf("%d", hb.GetID()))
-		}
 		err = opentracing.GlobalTracer().Inject(
-			span.Context(),
+			sc,
 			opentracing.Binary,
 			buf,
 		)
@@ -160,10 +359,6 @@ func Write(
 	}
 	// write body
 	bb := bodyBase{}
-	bv, err := json.Marshal(data)
-	if err != nil {
-		return err
-	}
 	bb.Len = int64(len(bv))
 	err = binary.Write(buf, binary.BigEndian, bb)
 	if err != nil {
@@ -173,6 +368,13 @@ func Write(
 	if err != nil {
 		return err
 	}
+	// write checksum
+	if hb.HasFlag(FlagChecksum) {
+		err = binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(buf.Bytes()))
+		if err != nil {
+			return err
+		}
+	}
 	message := buf.Bytes()
 	var offset int
 	for {
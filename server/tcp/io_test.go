@@ -0,0 +1,133 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func encodeFrame(t *testing.T, hb headerBase, values map[string]interface{}, body []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := writeRaw(context.Background(), hb, streamBase{}, values, body, &buf); err != nil {
+		t.Fatalf("writeRaw: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadFrame(t *testing.T) {
+	hb := headerBase{Magic: Magic, Version: ProtoVersion, ID: 7, Flag: FlagChecksum}
+	data := encodeFrame(t, hb, map[string]interface{}{"k": "v"}, []byte(`{"a":1}`))
+
+	h, b, _, err := readFrame(bytes.NewReader(data), nil, DefaultMaxFrameSize)
+	if err != nil {
+		t.Fatalf("readFrame: %v", err)
+	}
+	if h.GetID() != 7 || h.values["k"] != "v" || string(b.buf) != `{"a":1}` {
+		t.Fatalf("unexpected frame id:%d header:%v body:%s", h.GetID(), h.values, b.buf)
+	}
+}
+
+func TestWriteRawWithoutSpan(t *testing.T) {
+	// ctx 中没有 span 时不写入 trace, 对端按无 trace 读取
+	hb := headerBase{Magic: Magic, Version: ProtoVersion, ID: 7, WithTrace: UseTracer}
+	data := encodeFrame(t, hb, nil, []byte(`{"a":1}`))
+
+	h, b, sc, err := readFrame(bytes.NewReader(data), nil, DefaultMaxFrameSize)
+	if err != nil {
+		t.Fatalf("readFrame: %v", err)
+	}
+	if h.IsWithTrace() || sc != nil || string(b.buf) != `{"a":1}` {
+		t.Fatalf("want frame without trace, got trace:%d span:%v body:%s", h.WithTrace, sc, b.buf)
+	}
+}
+
+func TestReadFrameTruncated(t *testing.T) {
+	hb := headerBase{Magic: Magic, Version: ProtoVersion, ID: 7}
+	data := encodeFrame(t, hb, map[string]interface{}{"k": "v"}, []byte(`{"a":1}`))
+
+	for n := 1; n < len(data); n++ {
+		_, _, _, err := readFrame(bytes.NewReader(data[:n]), nil, DefaultMaxFrameSize)
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			t.Fatalf("truncated at %d: got err %v", n, err)
+		}
+	}
+}
+
+func TestReadFrameTooLarge(t *testing.T) {
+	hb := headerBase{Magic: Magic, Version: ProtoVersion, ID: 7}
+	data := encodeFrame(t, hb, nil, bytes.Repeat([]byte("a"), 64))
+
+	_, _, _, err := readFrame(bytes.NewReader(data), nil, 32)
+	if err != ErrFrameTooLarge {
+		t.Fatalf("oversized body: got err %v", err)
+	}
+	_, _, _, err = readFrame(bytes.NewReader(data), nil, 2)
+	if err != ErrFrameTooLarge {
+		t.Fatalf("oversized header: got err %v", err)
+	}
+	if !IsProtocolError(ErrFrameTooLarge) {
+		t.Fatal("ErrFrameTooLarge is not a protocol error")
+	}
+}
+
+func TestReadFrameNegativeLength(t *testing.T) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, headerBase{Magic: Magic, Version: ProtoVersion, Len: -1})
+	_, _, _, err := readFrame(bytes.NewReader(buf.Bytes()), nil, DefaultMaxFrameSize)
+	if err != ErrFrameTooLarge {
+		t.Fatalf("negative header len: got err %v", err)
+	}
+
+	buf.Reset()
+	binary.Write(&buf, binary.BigEndian, headerBase{Magic: Magic, Version: ProtoVersion, Len: 2})
+	buf.WriteString("{}")
+	binary.Write(&buf, binary.BigEndian, bodyBase{Len: -1 << 62})
+	_, _, _, err = readFrame(bytes.NewReader(buf.Bytes()), nil, DefaultMaxFrameSize)
+	if err != ErrFrameTooLarge {
+		t.Fatalf("negative body len: got err %v", err)
+	}
+}
+
+// TestEngineRejectsBadFrame 魔数错误、版本不支持及校验和不一致的帧不进入处理链, 连接被断开
+func TestEngineRejectsBadFrame(t *testing.T) {
+	good := headerBase{Magic: Magic, Version: ProtoVersion, ID: 1, Flag: FlagChecksum}
+	crc := encodeFrame(t, good, map[string]interface{}{"k": "v"}, []byte(`{"a":1}`))
+	// 翻转 body 的最后一个字节, CRC 不再一致
+	crc[len(crc)-5] ^= 0xff
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"bad magic", encodeFrame(t, headerBase{Magic: 0x1234, Version: ProtoVersion, ID: 1}, nil, nil)},
+		{"unsupported version", encodeFrame(t, headerBase{Magic: Magic, Version: ProtoVersion + 1, ID: 1}, nil, nil)},
+		{"checksum mismatch", crc},
+	}
+	for _, tt := range tests {
+		e := NewApp("tcp")
+		called := make(chan struct{}, 1)
+		e.Invoke(1, func(c *Context) {
+			called <- struct{}{}
+			c.Write(1, nil)
+		})
+		sc, cc := net.Pipe()
+		go e.ServeConn(sc)
+		go cc.Write(tt.data)
+
+		cc.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := cc.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("%s: want connection closed, got %v", tt.name, err)
+		}
+		select {
+		case <-called:
+			t.Fatalf("%s: handler called", tt.name)
+		default:
+		}
+		cc.Close()
+	}
+}
@@ -0,0 +1,364 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"github.com/ousanki/sagittarius/core/code"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrBreakerOpen = errors.New("tcp: circuit breaker open")
+
+// Sender Client 及 Balancer 的发送接口
+type Sender interface {
+	Send(ctx context.Context, id int64, header map[string]interface{}, req interface{}) (*Reply, error)
+}
+
+// Backoff 第 n 次重试前等待 [0, min(Base*2^n, Max)) 的随机时长, 零值使用 DefaultBackoff
+type Backoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+var DefaultBackoff = Backoff{
+	Base: 50 * time.Millisecond,
+	Max:  time.Second,
+}
+
+func (b Backoff) delay(attempt int) time.Duration {
+	if b.Base <= 0 {
+		b = DefaultBackoff
+	}
+	d := b.Base
+	for i := 0; i < attempt && (b.Max <= 0 || d < b.Max); i++ {
+		d *= 2
+	}
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// Breaker 统计窗口内请求数不少于 MinRequests 且失败率达到 ErrorRate 时熔断 Cooldown,
+// 之后放行一个探测请求, 成功则恢复, 失败则继续熔断
+type Breaker struct {
+	Window      time.Duration
+	MinRequests int
+	ErrorRate   float64
+	Cooldown    time.Duration
+}
+
+var DefaultBreaker = Breaker{
+	Window:      10 * time.Second,
+	MinRequests: 20,
+	ErrorRate:   0.5,
+	Cooldown:    5 * time.Second,
+}
+
+// CallPolicy 路由的调用策略; 重试及对冲只对 Idempotent 的路由生效,
+// 连接错误及 code.MarkRetryable 标记的错误码可重试
+type CallPolicy struct {
+	Idempotent bool
+	// 失败后的重试次数
+	MaxRetries int
+	Backoff    Backoff
+	// 请求超过 HedgeDelay 未回包时再发一份, 最多 MaxHedges 份, 取先成功的回包;
+	// Engine 按序处理同一连接上的请求, 对冲应配合 Balancer 使用
+	HedgeDelay time.Duration
+	MaxHedges  int
+	// 为 nil 时不熔断
+	Breaker *Breaker
+}
+
+// 熔断状态
+const (
+	_breakerClosed = iota
+	_breakerOpen
+	_breakerHalfOpen
+)
+
+type breaker struct {
+	cfg Breaker
+
+	mu          sync.Mutex
+	state       int
+	openedAt    time.Time
+	windowStart time.Time
+	total       int
+	failures    int
+	probing     bool
+}
+
+// allow 熔断中拒绝请求, 冷却结束后只放行一个探测请求
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case _breakerOpen:
+		if time.Since(b.openedAt) < b.cfg.Cooldown {
+			return false
+		}
+		b.state = _breakerHalfOpen
+		b.probing = true
+		return true
+	case _breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+func (b *breaker) record(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if b.state == _breakerHalfOpen {
+		b.probing = false
+		if ok {
+			b.state = _breakerClosed
+			b.windowStart = now
+			b.total = 0
+			b.failures = 0
+		} else {
+			b.state = _breakerOpen
+			b.openedAt = now
+		}
+		return
+	}
+	if b.state == _breakerOpen {
+		return
+	}
+	if now.Sub(b.windowStart) > b.cfg.Window {
+		b.windowStart = now
+		b.total = 0
+		b.failures = 0
+	}
+	b.total++
+	if !ok {
+		b.failures++
+	}
+	if b.total >= b.cfg.MinRequests && float64(b.failures)/float64(b.total) >= b.cfg.ErrorRate {
+		b.state = _breakerOpen
+		b.openedAt = now
+	}
+}
+
+// cancel 调用方取消的请求不计入统计, 探测请求被取消时放行下一个探测
+func (b *breaker) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == _breakerHalfOpen {
+		b.probing = false
+	}
+}
+
+type PolicyOption func(*PolicyClient)
+
+// PolicyClient 按路由策略重试、对冲及熔断, 包装 Client 或 Balancer
+type PolicyClient struct {
+	s        Sender
+	policies map[int64]CallPolicy
+	def      CallPolicy
+
+	mu       sync.Mutex
+	breakers map[int64]*breaker
+}
+
+func NewPolicyClient(s Sender, opts ...PolicyOption) *PolicyClient {
+	pc := &PolicyClient{
+		s:        s,
+		policies: make(map[int64]CallPolicy),
+		breakers: make(map[int64]*breaker),
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(pc)
+		}
+	}
+	return pc
+}
+
+func (pc *PolicyClient) policy(id int64) CallPolicy {
+	if p, ok := pc.policies[id]; ok {
+		return p
+	}
+	return pc.def
+}
+
+// breaker 每个路由一个熔断器
+func (pc *PolicyClient) breaker(id int64, cfg *Breaker) *breaker {
+	if cfg == nil {
+		return nil
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	b, ok := pc.breakers[id]
+	if !ok {
+		b = &breaker{cfg: *cfg, windowStart: time.Now()}
+		pc.breakers[id] = b
+	}
+	return b
+}
+
+// Send 按路由策略发送; 熔断中返回 ErrBreakerOpen, 重试耗尽时返回最后一次的结果
+func (pc *PolicyClient) Send(ctx context.Context, id int64, header map[string]interface{}, req interface{}) (*Reply, error) {
+	p := pc.policy(id)
+	br := pc.breaker(id, p.Breaker)
+	retries := 0
+	if p.Idempotent {
+		retries = p.MaxRetries
+	}
+
+	var (
+		r   *Reply
+		err error
+	)
+	for attempt := 0; ; attempt++ {
+		if br != nil && !br.allow() {
+			if attempt == 0 {
+				return nil, ErrBreakerOpen
+			}
+			return r, err
+		}
+		r, err = pc.hedge(ctx, p, id, header, req)
+		failed := err
+		if failed == nil {
+			failed = r.Err()
+		}
+		if br != nil {
+			if failed == context.Canceled {
+				br.cancel()
+			} else {
+				br.record(!isFailure(failed))
+			}
+		}
+		if attempt >= retries || !isRetryable(failed) {
+			return r, err
+		}
+
+		t := time.NewTimer(p.Backoff.delay(attempt))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return r, err
+		case <-t.C:
+		}
+	}
+}
+
+type sendResult struct {
+	r   *Reply
+	err error
+}
+
+// hedge 首个请求超过 HedgeDelay 未回包时再发一份, 返回先成功的回包, 其余请求随 ctx 取消
+func (pc *PolicyClient) hedge(ctx context.Context, p CallPolicy, id int64, header map[string]interface{}, req interface{}) (*Reply, error) {
+	if !p.Idempotent || p.HedgeDelay <= 0 || p.MaxHedges <= 0 {
+		return pc.s.Send(ctx, id, header, req)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := make(chan sendResult, p.MaxHedges+1)
+	send := func() {
+		r, err := pc.s.Send(ctx, id, header, req)
+		ch <- sendResult{r, err}
+	}
+	go send()
+	sent, inflight := 1, 1
+	t := time.NewTimer(p.HedgeDelay)
+	defer t.Stop()
+
+	var last sendResult
+	for {
+		select {
+		case res := <-ch:
+			inflight--
+			failed := res.err
+			if failed == nil {
+				failed = res.r.Err()
+			}
+			if !isRetryable(failed) {
+				return res.r, res.err
+			}
+			last = res
+			if inflight == 0 {
+				return last.r, last.err
+			}
+		case <-t.C:
+			if sent <= p.MaxHedges {
+				go send()
+				sent++
+				inflight++
+				t.Reset(p.HedgeDelay)
+			}
+		}
+	}
+}
+
+func (pc *PolicyClient) Call(ctx context.Context, id int64, req interface{}, resp interface{}) error {
+	r, err := pc.Send(ctx, id, nil, req)
+	if err != nil {
+		return err
+	}
+	if err = r.Err(); err != nil {
+		return err
+	}
+	if resp == nil {
+		return nil
+	}
+	return r.Decode(resp)
+}
+
+func (pc *PolicyClient) CallNamed(ctx context.Context, method string, req interface{}, resp interface{}) error {
+	return pc.Call(ctx, MethodID(method), req, resp)
+}
+
+// isRetryable 传输错误及标记为可重试的错误码, 调用方取消或超时、本地编码失败等确定性错误不重试
+func isRetryable(err error) bool {
+	if err == nil || err == context.Canceled || err == context.DeadlineExceeded || err == ErrBreakerOpen {
+		return false
+	}
+	return isTransportError(err) || code.IsRetryable(err)
+}
+
+// isTransportError 连接断开、网络错误及没有可用节点, 与请求内容无关
+func isTransportError(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, ErrClientClosed) ||
+		errors.Is(err, ErrConnClosed) || errors.Is(err, ErrNoNode) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// isFailure 计入熔断的失败, 包括超时及 ErrInternal
+func isFailure(err error) bool {
+	return err == context.DeadlineExceeded || isNodeFailure(err) || code.IsRetryable(err)
+}
+
+// SetRoutePolicy 路由的调用策略
+func SetRoutePolicy(id int64, p CallPolicy) PolicyOption {
+	return func(pc *PolicyClient) {
+		pc.policies[id] = p
+	}
+}
+
+// SetDefaultPolicy 未设置策略的路由使用的策略, 默认不重试不熔断
+func SetDefaultPolicy(p CallPolicy) PolicyOption {
+	return func(pc *PolicyClient) {
+		pc.def = p
+	}
+}
@@ -0,0 +1,180 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"github.com/ousanki/sagittarius/core/code"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errConnReset = &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset")}
+
+// fakeSender 按调用次序返回 results 中的结果, 超出后返回最后一个
+type fakeSender struct {
+	mu      sync.Mutex
+	calls   int
+	results []func(ctx context.Context) (*Reply, error)
+}
+
+func (fs *fakeSender) Send(ctx context.Context, id int64, header map[string]interface{}, req interface{}) (*Reply, error) {
+	fs.mu.Lock()
+	i := fs.calls
+	fs.calls++
+	if i >= len(fs.results) {
+		i = len(fs.results) - 1
+	}
+	fn := fs.results[i]
+	fs.mu.Unlock()
+	return fn(ctx)
+}
+
+func (fs *fakeSender) count() int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.calls
+}
+
+func okReply(ctx context.Context) (*Reply, error) {
+	return &Reply{Header: map[string]interface{}{}}, nil
+}
+
+func failReply(ctx context.Context) (*Reply, error) {
+	return nil, errConnReset
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Base: 10 * time.Millisecond, Max: 80 * time.Millisecond}
+	for attempt := 0; attempt < 10; attempt++ {
+		limit := b.Base << uint(attempt)
+		if limit > b.Max {
+			limit = b.Max
+		}
+		for i := 0; i < 100; i++ {
+			if d := b.delay(attempt); d < 0 || d >= limit {
+				t.Fatalf("attempt %d: delay %v out of [0, %v)", attempt, d, limit)
+			}
+		}
+	}
+	// 零值使用 DefaultBackoff
+	if d := (Backoff{}).delay(100); d < 0 || d >= DefaultBackoff.Max {
+		t.Fatalf("default backoff delay %v out of range", d)
+	}
+}
+
+func TestPolicyRetry(t *testing.T) {
+	fs := &fakeSender{results: []func(context.Context) (*Reply, error){failReply, failReply, okReply}}
+	p := CallPolicy{Idempotent: true, MaxRetries: 2, Backoff: Backoff{Base: time.Millisecond, Max: time.Millisecond}}
+	pc := NewPolicyClient(fs, SetRoutePolicy(1, p))
+	if _, err := pc.Send(context.Background(), 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := fs.count(); n != 3 {
+		t.Fatalf("want 3 sends, got %d", n)
+	}
+
+	// 非幂等路由不重试
+	fs = &fakeSender{results: []func(context.Context) (*Reply, error){failReply, okReply}}
+	pc = NewPolicyClient(fs, SetDefaultPolicy(CallPolicy{MaxRetries: 2}))
+	if _, err := pc.Send(context.Background(), 1, nil, nil); err != errConnReset {
+		t.Fatalf("want errConnReset, got %v", err)
+	}
+	if n := fs.count(); n != 1 {
+		t.Fatalf("want 1 send, got %d", n)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	code.MarkRetryable(1099)
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{errConnReset, true},
+		{ErrConnClosed, true},
+		{code.BuildCode(1099, "busy"), true},
+		{code.BuildCode(1098, "bad"), false},
+		// 本地编码失败等确定性错误
+		{errors.New("json: unsupported type: chan int"), false},
+		{context.DeadlineExceeded, false},
+		{ErrBreakerOpen, false},
+	} {
+		if got := isRetryable(tc.err); got != tc.want {
+			t.Fatalf("%v: want %v, got %v", tc.err, tc.want, got)
+		}
+	}
+}
+
+func TestPolicyHedge(t *testing.T) {
+	slow := func(ctx context.Context) (*Reply, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	fs := &fakeSender{results: []func(context.Context) (*Reply, error){slow, okReply}}
+	p := CallPolicy{Idempotent: true, HedgeDelay: 10 * time.Millisecond, MaxHedges: 1}
+	pc := NewPolicyClient(fs, SetRoutePolicy(1, p))
+
+	start := time.Now()
+	if _, err := pc.Send(context.Background(), 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("hedged request took %v", d)
+	}
+	if n := fs.count(); n != 2 {
+		t.Fatalf("want 2 sends, got %d", n)
+	}
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	cfg := Breaker{Window: time.Minute, MinRequests: 2, ErrorRate: 0.5, Cooldown: 20 * time.Millisecond}
+	fs := &fakeSender{results: []func(context.Context) (*Reply, error){failReply, failReply, failReply, okReply}}
+	pc := NewPolicyClient(fs, SetDefaultPolicy(CallPolicy{Breaker: &cfg}))
+	send := func() error {
+		_, err := pc.Send(context.Background(), 1, nil, nil)
+		return err
+	}
+
+	// closed -> open
+	send()
+	send()
+	if err := send(); err != ErrBreakerOpen {
+		t.Fatalf("want ErrBreakerOpen, got %v", err)
+	}
+	// 冷却后放行一个探测, 失败继续熔断
+	time.Sleep(30 * time.Millisecond)
+	if err := send(); err != errConnReset {
+		t.Fatalf("want probe error, got %v", err)
+	}
+	if err := send(); err != ErrBreakerOpen {
+		t.Fatalf("want ErrBreakerOpen after failed probe, got %v", err)
+	}
+	// 探测成功后恢复
+	time.Sleep(30 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if err := send(); err != nil {
+			t.Fatalf("send %d after recovery: %v", i, err)
+		}
+	}
+}
+
+func TestBreakerCanceledProbe(t *testing.T) {
+	cfg := Breaker{Window: time.Minute, MinRequests: 1, ErrorRate: 0.5, Cooldown: 10 * time.Millisecond}
+	canceled := func(ctx context.Context) (*Reply, error) {
+		return nil, context.Canceled
+	}
+	fs := &fakeSender{results: []func(context.Context) (*Reply, error){failReply, canceled, okReply}}
+	pc := NewPolicyClient(fs, SetDefaultPolicy(CallPolicy{Breaker: &cfg}))
+
+	pc.Send(context.Background(), 1, nil, nil)
+	time.Sleep(20 * time.Millisecond)
+	// 探测请求被取消, 下一个请求仍可探测
+	if _, err := pc.Send(context.Background(), 1, nil, nil); err != context.Canceled {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+	if _, err := pc.Send(context.Background(), 1, nil, nil); err != nil {
+		t.Fatalf("want probe after canceled probe, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package tcp
+
+import (
+	"context"
+	"github.com/opentracing/opentracing-go"
+	"go.opentelemetry.io/otel/propagation"
+	"sync"
+)
+
+var (
+	_propagatorMu sync.RWMutex
+	_propagator   propagation.TextMapPropagator = propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	)
+)
+
+// SetPropagator 替换 UseTraceContext 模式的 propagator, 默认为 W3C TraceContext + Baggage
+func SetPropagator(p propagation.TextMapPropagator) {
+	_propagatorMu.Lock()
+	defer _propagatorMu.Unlock()
+	_propagator = p
+}
+
+func propagator() propagation.TextMapPropagator {
+	_propagatorMu.RLock()
+	defer _propagatorMu.RUnlock()
+	return _propagator
+}
+
+type remoteSpanKey struct{}
+
+func withRemoteSpanContext(ctx context.Context, sc opentracing.SpanContext) context.Context {
+	return context.WithValue(ctx, remoteSpanKey{}, sc)
+}
+
+// RemoteSpanContext 请求以 UseTracer 携带的对端 SpanContext, 没有时为 nil
+func RemoteSpanContext(ctx context.Context) opentracing.SpanContext {
+	sc, _ := ctx.Value(remoteSpanKey{}).(opentracing.SpanContext)
+	return sc
+}
+
+// headerCarrier header values 作为 TextMapCarrier, 只读取字符串值
+type headerCarrier map[string]interface{}
+
+func (hc headerCarrier) Get(key string) string {
+	s, _ := hc[key].(string)
+	return s
+}
+
+func (hc headerCarrier) Set(key string, value string) {
+	hc[key] = value
+}
+
+func (hc headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(hc))
+	for k := range hc {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// extractTrace 从 header values 中取出 traceparent/tracestate/baggage 写入 ctx
+func extractTrace(ctx context.Context, values map[string]interface{}) context.Context {
+	if values == nil {
+		return ctx
+	}
+	return propagator().Extract(ctx, headerCarrier(values))
+}
+
+// injectTrace 返回写入 ctx 中 trace 的 header values 副本, 不修改原 map
+func injectTrace(ctx context.Context, values map[string]interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(values)+3)
+	for k, v := range values {
+		m[k] = v
+	}
+	propagator().Inject(ctx, headerCarrier(m))
+	return m
+}
@@ -0,0 +1,234 @@
+package tcp
+
+import (
+	"errors"
+	"github.com/ousanki/sagittarius/metric"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 建议的每个连接出站队列可排队的帧数, 队列默认关闭, 通过 SetWriteQueue 开启
+	DefaultWriteQueueSize = 1024
+	// 连接关闭时写出排队帧的最长时间
+	_drainTimeout = 5 * time.Second
+	// 编码后的帧中 Flag 的偏移: Magic(2) + Version(1)
+	_flagOffset = 3
+)
+
+// QueuePolicy 出站队列满时的处理策略
+type QueuePolicy int
+
+const (
+	// 阻塞写入方直到队列有空位
+	QueueBlock QueuePolicy = iota
+	// 丢弃最早排队的非流帧, 流帧不丢弃, 队列中全部为流帧时同 QueueBlock
+	QueueDropOldest
+	// 断开慢连接
+	QueueDisconnect
+)
+
+func (p QueuePolicy) String() string {
+	switch p {
+	case QueueBlock:
+		return "block"
+	case QueueDropOldest:
+		return "drop-oldest"
+	case QueueDisconnect:
+		return "disconnect"
+	}
+	return "unknown"
+}
+
+var (
+	ErrQueueFull  = errors.New("tcp: write queue full")
+	ErrConnClosed = errors.New("tcp: connection closed")
+)
+
+// QueueStats 出站队列统计
+type QueueStats struct {
+	// 启用队列的连接数
+	Conns int
+	// 所有连接排队中的帧数
+	Depth int
+	// 单个连接排队帧数的最大值
+	MaxDepth int
+	// 因 QueueDropOldest 丢弃的帧数
+	Dropped uint64
+	// 因 QueueDisconnect 断开的连接数
+	Disconnected uint64
+}
+
+// writeQueue 连接的出站队列, 每次 Write 为一帧, 由单独的 goroutine 写出
+type writeQueue struct {
+	server *Engine
+	size   int
+	policy QueuePolicy
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	frames [][]byte
+	closed bool
+	err    error
+}
+
+func newWriteQueue(s *Engine) *writeQueue {
+	q := &writeQueue{
+		server: s,
+		size:   s.queueSize,
+		policy: s.queuePolicy,
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *writeQueue) Write(p []byte) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if q.err != nil {
+			return 0, q.err
+		}
+		if q.closed {
+			return 0, ErrConnClosed
+		}
+		if len(q.frames) < q.size {
+			break
+		}
+		switch q.policy {
+		case QueueDropOldest:
+			if !q.dropOldest() {
+				q.cond.Wait()
+			}
+		case QueueDisconnect:
+			q.err = ErrQueueFull
+			q.cond.Broadcast()
+			atomic.AddUint64(&q.server.queueDisconnected, 1)
+			return 0, ErrQueueFull
+		default:
+			q.cond.Wait()
+		}
+	}
+	frame := make([]byte, len(p))
+	copy(frame, p)
+	q.frames = append(q.frames, frame)
+	q.cond.Broadcast()
+	return len(p), nil
+}
+
+// dropOldest 丢弃最早的非流帧, 流帧丢失后对端流状态无法恢复
+func (q *writeQueue) dropOldest() bool {
+	for i, frame := range q.frames {
+		if len(frame) > _flagOffset && frame[_flagOffset]&FlagStream != 0 {
+			continue
+		}
+		copy(q.frames[i:], q.frames[i+1:])
+		q.frames[len(q.frames)-1] = nil
+		q.frames = q.frames[:len(q.frames)-1]
+		atomic.AddUint64(&q.server.queueDropped, 1)
+		return true
+	}
+	return false
+}
+
+// Len 排队中的帧数
+func (q *writeQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.frames)
+}
+
+// close 不再接受写入, 已排队的帧写出后 run 返回
+func (q *writeQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// fail 之后的写入返回 err, 未写出的帧丢弃
+func (q *writeQueue) fail(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err == nil {
+		q.err = err
+	}
+	q.frames = nil
+	q.cond.Broadcast()
+}
+
+// run 批量写出排队的帧直到队列关闭或写出失败, 返回导致退出的错误
+func (q *writeQueue) run(w io.Writer) error {
+	for {
+		q.mu.Lock()
+		for len(q.frames) == 0 && q.err == nil && !q.closed {
+			q.cond.Wait()
+		}
+		if q.err != nil {
+			err := q.err
+			q.mu.Unlock()
+			return err
+		}
+		if len(q.frames) == 0 {
+			q.mu.Unlock()
+			return ErrConnClosed
+		}
+		bufs := net.Buffers(q.frames)
+		q.frames = nil
+		q.cond.Broadcast()
+		q.mu.Unlock()
+
+		if _, err := bufs.WriteTo(w); err != nil {
+			q.fail(err)
+			return err
+		}
+	}
+}
+
+// registerQueueMetric 开启出站队列时以 "tcp.queue.<监听地址>" 注册到 metric
+func (s *Engine) registerQueueMetric(addr net.Addr) {
+	if s.queueSize <= 0 {
+		return
+	}
+	s.mu.Lock()
+	s.queueMetric = "tcp.queue." + addr.String()
+	s.mu.Unlock()
+	metric.Register(s.queueMetric, func() interface{} {
+		return s.QueueStats()
+	})
+}
+
+func (s *Engine) deregisterQueueMetric() {
+	s.mu.Lock()
+	name := s.queueMetric
+	s.queueMetric = ""
+	s.mu.Unlock()
+	if name != "" {
+		metric.Unregister(name)
+	}
+}
+
+// QueueStats 当前所有连接出站队列的统计
+func (s *Engine) QueueStats() QueueStats {
+	stats := QueueStats{
+		Dropped:      atomic.LoadUint64(&s.queueDropped),
+		Disconnected: atomic.LoadUint64(&s.queueDisconnected),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.activeConn {
+		if c.out == nil {
+			continue
+		}
+		n := c.out.Len()
+		stats.Conns++
+		stats.Depth += n
+		if n > stats.MaxDepth {
+			stats.MaxDepth = n
+		}
+	}
+	return stats
+}
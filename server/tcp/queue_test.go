@@ -0,0 +1,73 @@
+package tcp
+
+import (
+	"github.com/ousanki/sagittarius/metric"
+	"net"
+	"testing"
+	"time"
+)
+
+func queueFrame(flag uint8, tag byte) []byte {
+	return []byte{0, 0, ProtoVersion, flag, tag}
+}
+
+func TestQueueDropOldestKeepsStream(t *testing.T) {
+	e := NewApp("tcp")
+	e.WithOptions(SetWriteQueue(2, QueueDropOldest))
+	q := newWriteQueue(e)
+
+	q.Write(queueFrame(FlagStream, 's'))
+	q.Write(queueFrame(0, 'a'))
+	q.Write(queueFrame(0, 'b'))
+	if len(q.frames) != 2 || q.frames[0][4] != 's' || q.frames[1][4] != 'b' {
+		t.Fatalf("want stream frame kept and oldest plain frame dropped, got %q", q.frames)
+	}
+	if e.QueueStats().Dropped != 1 {
+		t.Fatalf("want 1 dropped, got %d", e.QueueStats().Dropped)
+	}
+
+	// 全部为流帧时阻塞直到写出
+	q.frames = [][]byte{queueFrame(FlagStream, 's'), queueFrame(FlagStream, 't')}
+	done := make(chan error, 1)
+	go func() {
+		_, err := q.Write(queueFrame(FlagStream, 'u'))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("want write blocked, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	q.fail(ErrConnClosed)
+	if err := <-done; err != ErrConnClosed {
+		t.Fatalf("want ErrConnClosed, got %v", err)
+	}
+}
+
+func TestQueueMetric(t *testing.T) {
+	e := NewApp("tcp")
+	if e.queueSize != 0 {
+		t.Fatalf("want write queue disabled by default, got %d", e.queueSize)
+	}
+	e.WithOptions(SetWriteQueue(DefaultWriteQueueSize, QueueBlock))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go e.Serve(l)
+	name := "tcp.queue." + l.Addr().String()
+	deadline := time.Now().Add(time.Second)
+	for metric.Collect()[name] == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("metric %s not registered", name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := metric.Collect()[name].(QueueStats); !ok {
+		t.Fatalf("want QueueStats, got %T", metric.Collect()[name])
+	}
+	e.Stop()
+	if _, ok := metric.Collect()[name]; ok {
+		t.Fatal("metric kept after Stop")
+	}
+}
@@ -0,0 +1,282 @@
+// Package record 录制 Engine 的真实请求及回包, 并回放对比.
+// 脱敏后的 header 及 body 字段回放时按 "***" 原样发送, 依赖这些值的请求无法如实回放
+package record
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	rotate "github.com/lestrrat-go/file-rotatelogs"
+	"github.com/ousanki/sagittarius/core/log"
+	"github.com/ousanki/sagittarius/server/tcp"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	_defaultPath     = "./record"
+	_defaultName     = "traffic"
+	_defaultSaveDays = 3
+)
+
+// Redacted 脱敏后的值, 回放对比时视为任意值
+const Redacted = "***"
+
+// EncodingBase64 非 JSON body 以 base64 字符串保存
+const EncodingBase64 = "base64"
+
+// Record 一次请求, 每行一个 JSON
+type Record struct {
+	Time     time.Time              `json:"time"`
+	Duration time.Duration          `json:"duration"`
+	Remote   string                 `json:"remote,omitempty"`
+	ID       int64                  `json:"id"`
+	Method   string                 `json:"method,omitempty"`
+	Header   map[string]interface{} `json:"header"`
+	Body     json.RawMessage        `json:"body,omitempty"`
+	// Encoding 为 EncodingBase64 时 Body 为原始字节的 base64 字符串
+	Encoding string  `json:"encoding,omitempty"`
+	Replies  []Reply `json:"replies"`
+}
+
+// RawBody 还原请求的原始字节
+func (rec *Record) RawBody() ([]byte, error) {
+	return rawBody(rec.Body, rec.Encoding)
+}
+
+type Reply struct {
+	ID     int64                  `json:"id"`
+	Header map[string]interface{} `json:"header"`
+	Body   json.RawMessage        `json:"body,omitempty"`
+	// Encoding 同 Record.Encoding
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// RawBody 还原回包的原始字节
+func (rp *Reply) RawBody() ([]byte, error) {
+	return rawBody(rp.Body, rp.Encoding)
+}
+
+func rawBody(body json.RawMessage, encoding string) ([]byte, error) {
+	if encoding != EncodingBase64 {
+		return body, nil
+	}
+	var s string
+	if err := json.Unmarshal(body, &s); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
+
+type Option func(*Recorder)
+
+// Recorder 录制中间件, 按天/小时切分文件
+type Recorder struct {
+	path     string
+	name     string
+	rotation log.Rotation
+	saveDays int
+	rate     float64
+	headers  map[string]struct{}
+	fields   map[string]struct{}
+
+	mu     sync.Mutex
+	writer *rotate.RotateLogs
+	rand   *rand.Rand
+	once   sync.Once
+}
+
+func New(opts ...Option) *Recorder {
+	r := &Recorder{
+		path:     _defaultPath,
+		name:     _defaultName,
+		rotation: log.RotationDay,
+		saveDays: _defaultSaveDays,
+		rate:     1,
+		headers:  make(map[string]struct{}),
+		fields:   make(map[string]struct{}),
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(r)
+		}
+	}
+	return r
+}
+
+func (r *Recorder) build() {
+	r.once.Do(func() {
+		path := strings.TrimSuffix(r.path, "/") + "/"
+		w, err := rotate.New(
+			path+r.name+r.rotation.Format(),
+			rotate.WithLinkName(path+r.name+".log"),
+			rotate.WithMaxAge(time.Hour*24*time.Duration(r.saveDays)),
+			rotate.WithRotationTime(r.rotation.Duration()),
+		)
+		if err != nil {
+			panic(fmt.Sprintf("init recorder new roate_log err:%v", err))
+		}
+		r.writer = w
+	})
+}
+
+// Handle 中间件, 通过 Group.Use 安装; 流式请求不录制
+func (r *Recorder) Handle(c *tcp.Context) {
+	if c.IsStream() || !r.sample() {
+		c.Next()
+		return
+	}
+	rec := &Record{
+		Time:   time.Now(),
+		ID:     c.ID(),
+		Method: c.Method(),
+		Header: r.redactHeader(c.HeaderValues()),
+	}
+	rec.Body, rec.Encoding = r.redactBody(c.Body())
+	if remote, ok := c.Ctx().Value("remote").(string); ok {
+		rec.Remote = remote
+	}
+	rc := &recording{rec: rec}
+	c.OnWrite(func(id int64, header map[string]interface{}, body []byte) {
+		reply := Reply{ID: id, Header: r.redactHeader(header)}
+		reply.Body, reply.Encoding = r.redactBody(body)
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		rc.rec.Replies = append(rc.rec.Replies, reply)
+	})
+	c.Next()
+	r.write(rc)
+}
+
+// recording 录制中的请求, 超时回包可能与处理链并发写入
+type recording struct {
+	mu  sync.Mutex
+	rec *Record
+}
+
+func (r *Recorder) sample() bool {
+	if r.rate >= 1 {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rand.Float64() < r.rate
+}
+
+func (r *Recorder) write(rc *recording) {
+	rc.mu.Lock()
+	rc.rec.Duration = time.Since(rc.rec.Time)
+	bs, err := json.Marshal(rc.rec)
+	rc.mu.Unlock()
+	if err != nil {
+		return
+	}
+	r.build()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writer.Write(append(bs, '\n'))
+}
+
+func (r *Recorder) redactHeader(header map[string]interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(header))
+	for k, v := range header {
+		if _, ok := r.headers[k]; ok {
+			v = Redacted
+		}
+		m[k] = v
+	}
+	return m
+}
+
+// redactBody 按字段名脱敏任意层级, 非 JSON body 不脱敏, 以 base64 保存原始字节
+func (r *Recorder) redactBody(body []byte) (json.RawMessage, string) {
+	if len(body) == 0 {
+		return nil, ""
+	}
+	if !json.Valid(body) {
+		bs, _ := json.Marshal(base64.StdEncoding.EncodeToString(body))
+		return bs, EncodingBase64
+	}
+	if len(r.fields) == 0 {
+		return append(json.RawMessage(nil), body...), ""
+	}
+	var v interface{}
+	tcp.UnmarshalUseNumber(body, &v)
+	bs, err := json.Marshal(r.redactValue(v))
+	if err != nil {
+		return nil, ""
+	}
+	return bs, ""
+}
+
+func (r *Recorder) redactValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, vv := range x {
+			if _, ok := r.fields[k]; ok {
+				x[k] = Redacted
+			} else {
+				x[k] = r.redactValue(vv)
+			}
+		}
+	case []interface{}:
+		for i, vv := range x {
+			x[i] = r.redactValue(vv)
+		}
+	}
+	return v
+}
+
+func SetPath(path string) Option {
+	return func(r *Recorder) {
+		r.path = path
+	}
+}
+
+func SetName(name string) Option {
+	return func(r *Recorder) {
+		r.name = name
+	}
+}
+
+func SetRotation(rotation log.Rotation) Option {
+	return func(r *Recorder) {
+		r.rotation = rotation
+	}
+}
+
+func SetSaveDays(days int) Option {
+	return func(r *Recorder) {
+		r.saveDays = days
+	}
+}
+
+// SetSampleRate 采样比例 (0, 1]
+func SetSampleRate(rate float64) Option {
+	return func(r *Recorder) {
+		if rate > 0 && rate <= 1 {
+			r.rate = rate
+		}
+	}
+}
+
+// SetRedactHeaders 脱敏的 header key, 回放时发送 "***", 服务端依赖该 header 时回放结果不可信
+func SetRedactHeaders(keys ...string) Option {
+	return func(r *Recorder) {
+		for _, k := range keys {
+			r.headers[k] = struct{}{}
+		}
+	}
+}
+
+// SetRedactFields 脱敏的 body 字段名, 匹配任意层级; 回放时同样发送 "***"
+func SetRedactFields(keys ...string) Option {
+	return func(r *Recorder) {
+		for _, k := range keys {
+			r.fields[k] = struct{}{}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package record
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/ousanki/sagittarius/server/tcp"
+	"github.com/ousanki/sagittarius/server/tcp/tcptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const _echoID = 1
+
+func startEcho(t *testing.T, opts ...Option) (*tcptest.Server, string) {
+	t.Helper()
+	dir := t.TempDir()
+	e := tcp.NewApp("tcp")
+	e.Use(New(append([]Option{SetPath(dir)}, opts...)...).Handle)
+	e.Invoke(_echoID, func(c *tcp.Context) {
+		c.Write(c.ID(), string(c.Body()))
+	})
+	srv := tcptest.NewServer(e)
+	t.Cleanup(func() {
+		srv.Close()
+		e.Stop()
+	})
+	return srv, filepath.Join(dir, _defaultName+".log")
+}
+
+// readRecords 录制在回包之后写入, 轮询等待 n 条
+func readRecords(t *testing.T, path string, n int) []byte {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		bs, _ := os.ReadFile(path)
+		if bytes.Count(bs, []byte("\n")) >= n {
+			return bs
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want %d records, got %q", n, bs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRecordRawBody(t *testing.T) {
+	srv, path := startEcho(t)
+	raw := []byte("\x00\"raw\" body\xff")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := srv.Client.SendRaw(ctx, _echoID, nil, raw); err != nil {
+		t.Fatal(err)
+	}
+	bs := readRecords(t, path, 1)
+
+	report, err := NewReplayer(srv.Client, SetReplaySpeed(0)).Replay(ctx, bytes.NewReader(bs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Matched != 1 {
+		t.Fatalf("want byte-for-byte replay, got %s", report)
+	}
+}
+
+func TestRecordEncoding(t *testing.T) {
+	r := New()
+	raw := []byte{0, 1, 2}
+	body, enc := r.redactBody(raw)
+	if enc != EncodingBase64 {
+		t.Fatalf("want base64 encoding, got %q", enc)
+	}
+	rec := &Record{Body: body, Encoding: enc}
+	if got, err := rec.RawBody(); err != nil || !bytes.Equal(got, raw) {
+		t.Fatalf("want %v, got %v %v", raw, got, err)
+	}
+	if _, enc = r.redactBody([]byte(`{"a":1}`)); enc != "" {
+		t.Fatalf("want no encoding for JSON body, got %q", enc)
+	}
+}
+
+func TestRecordRedact(t *testing.T) {
+	srv, path := startEcho(t, SetRedactFields("token"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := srv.Client.SendRaw(ctx, _echoID, nil, []byte(`{"token":"secret","n":12345678901234567}`)); err != nil {
+		t.Fatal(err)
+	}
+	var rec Record
+	if err := json.Unmarshal(readRecords(t, path, 1), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"n":12345678901234567,"token":"***"}`; string(rec.Body) != want {
+		t.Fatalf("want %s, got %s", want, rec.Body)
+	}
+}
+
+// TestRecordTimeout go test -race, 超时回包与处理链并发写入录制
+func TestRecordTimeout(t *testing.T) {
+	dir := t.TempDir()
+	e := tcp.NewApp("tcp")
+	e.Use(New(SetPath(dir)).Handle)
+	replied := make(chan struct{})
+	e.Timeout(20*time.Millisecond).Invoke(_echoID, func(c *tcp.Context) {
+		<-replied
+		c.Write(c.ID(), "late")
+	})
+	srv := tcptest.NewServer(e)
+	defer func() {
+		srv.Close()
+		e.Stop()
+	}()
+
+	r, err := srv.Send(_echoID, nil, nil)
+	close(replied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcptest.AssertCode(t, r.Err(), -2)
+	var rec Record
+	if err = json.Unmarshal(readRecords(t, filepath.Join(dir, _defaultName+".log"), 1), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.Replies) == 0 || rec.Replies[0].Header[tcp.HeaderCode] != float64(-2) {
+		t.Fatalf("want timeout reply recorded, got %+v", rec.Replies)
+	}
+}
@@ -0,0 +1,261 @@
+package record
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ousanki/sagittarius/server/tcp"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+const _defaultReplayTimeout = 3 * time.Second
+
+type ReplayOption func(*Replayer)
+
+// Replayer 按录制时间间隔重放请求, 与录制的首个回包对比.
+// body 按录制的原始字节发送; 脱敏的 header 及字段以 "***" 发送, 此类请求的回放结果仅供参考
+type Replayer struct {
+	client  *tcp.Client
+	speed   float64
+	timeout time.Duration
+	ignore  map[string]struct{}
+}
+
+// Diff 一处不一致
+type Diff struct {
+	Index  int
+	ID     int64
+	Method string
+	Field  string
+	Want   string
+	Got    string
+}
+
+type Report struct {
+	Total   int
+	Matched int
+	Diffs   []Diff
+}
+
+func (r *Report) OK() bool {
+	return len(r.Diffs) == 0
+}
+
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "replayed:%d matched:%d diffs:%d\n", r.Total, r.Matched, len(r.Diffs))
+	for _, d := range r.Diffs {
+		name := d.Method
+		if name == "" {
+			name = fmt.Sprintf("%d", d.ID)
+		}
+		fmt.Fprintf(&b, "#%d %s %s: want %s, got %s\n", d.Index, name, d.Field, d.Want, d.Got)
+	}
+	return b.String()
+}
+
+func NewReplayer(cl *tcp.Client, opts ...ReplayOption) *Replayer {
+	rp := &Replayer{
+		client:  cl,
+		speed:   1,
+		timeout: _defaultReplayTimeout,
+		ignore:  map[string]struct{}{tcp.HeaderSeq: {}, tcp.HeaderTimeout: {}},
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(rp)
+		}
+	}
+	return rp
+}
+
+// Replay 读取录制文件逐条重放, 请求可能并发
+func (rp *Replayer) Replay(ctx context.Context, in io.Reader) (*Report, error) {
+	var records []*Record
+	dec := json.NewDecoder(in)
+	for {
+		rec := new(Record)
+		if err := dec.Decode(rec); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	report := &Report{Total: len(records)}
+	if len(records) == 0 {
+		return report, nil
+	}
+
+	start := time.Now()
+	base := records[0].Time
+	results := make([][]Diff, len(records))
+	var wg sync.WaitGroup
+	for i, rec := range records {
+		if rp.speed > 0 {
+			at := time.Duration(float64(rec.Time.Sub(base)) / rp.speed)
+			if d := at - time.Since(start); d > 0 {
+				select {
+				case <-time.After(d):
+				case <-ctx.Done():
+					wg.Wait()
+					return nil, ctx.Err()
+				}
+			}
+		}
+		wg.Add(1)
+		go func(i int, rec *Record) {
+			defer wg.Done()
+			results[i] = rp.replay(ctx, i, rec)
+		}(i, rec)
+	}
+	wg.Wait()
+
+	for _, diffs := range results {
+		if len(diffs) == 0 {
+			report.Matched++
+		}
+		report.Diffs = append(report.Diffs, diffs...)
+	}
+	return report, nil
+}
+
+func (rp *Replayer) replay(ctx context.Context, index int, rec *Record) []Diff {
+	diff := func(field string, want, got interface{}) []Diff {
+		return []Diff{{
+			Index:  index,
+			ID:     rec.ID,
+			Method: rec.Method,
+			Field:  field,
+			Want:   fmt.Sprintf("%v", want),
+			Got:    fmt.Sprintf("%v", got),
+		}}
+	}
+
+	header := make(map[string]interface{}, len(rec.Header))
+	for k, v := range rec.Header {
+		if k != tcp.HeaderSeq {
+			header[k] = v
+		}
+	}
+	req, err := rec.RawBody()
+	if err != nil {
+		return diff("body", "raw body", err)
+	}
+	sctx, cancel := context.WithTimeout(ctx, rp.timeout)
+	defer cancel()
+	reply, err := rp.client.SendRaw(sctx, rec.ID, header, req)
+	if err != nil {
+		if err == context.DeadlineExceeded && len(rec.Replies) == 0 {
+			return nil
+		}
+		return diff("error", "reply", err)
+	}
+	if len(rec.Replies) == 0 {
+		return diff("reply", "none", string(reply.Body))
+	}
+
+	want := rec.Replies[0]
+	var diffs []Diff
+	if want.ID != reply.ID {
+		diffs = append(diffs, diff("id", want.ID, reply.ID)...)
+	}
+	keys := make(map[string]struct{})
+	for k := range want.Header {
+		keys[k] = struct{}{}
+	}
+	for k := range reply.Header {
+		keys[k] = struct{}{}
+	}
+	for k := range keys {
+		if _, ok := rp.ignore[k]; ok {
+			continue
+		}
+		if !match(want.Header[k], reply.Header[k]) {
+			diffs = append(diffs, diff("header."+k, want.Header[k], reply.Header[k])...)
+		}
+	}
+	if !matchBody(want, reply.Body) {
+		diffs = append(diffs, diff("body", string(want.Body), string(reply.Body))...)
+	}
+	return diffs
+}
+
+// matchBody 非 JSON body 逐字节比较, JSON body 按 match 比较
+func matchBody(want Reply, got []byte) bool {
+	if want.Encoding == EncodingBase64 {
+		raw, err := want.RawBody()
+		return err == nil && bytes.Equal(raw, got)
+	}
+	if len(want.Body) == 0 || len(got) == 0 {
+		return len(want.Body) == len(got)
+	}
+	var wb, gb interface{}
+	if tcp.UnmarshalUseNumber(want.Body, &wb) != nil || tcp.UnmarshalUseNumber(got, &gb) != nil {
+		return bytes.Equal(want.Body, got)
+	}
+	return match(wb, gb)
+}
+
+// match 深度比较, 录制值为 Redacted 时视为相等
+func match(want, got interface{}) bool {
+	if s, ok := want.(string); ok && s == Redacted {
+		return true
+	}
+	switch w := want.(type) {
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok || len(w) != len(g) {
+			return false
+		}
+		for k, v := range w {
+			if !match(v, g[k]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok || len(w) != len(g) {
+			return false
+		}
+		for i := range w {
+			if !match(w[i], g[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(want, got)
+}
+
+// SetReplaySpeed 相对录制速度的倍数, 0 表示不等待
+func SetReplaySpeed(speed float64) ReplayOption {
+	return func(rp *Replayer) {
+		if speed >= 0 {
+			rp.speed = speed
+		}
+	}
+}
+
+// SetReplayTimeout 单个请求等待回包的时长, 录制中无回包的请求超时视为一致
+func SetReplayTimeout(timeout time.Duration) ReplayOption {
+	return func(rp *Replayer) {
+		rp.timeout = timeout
+	}
+}
+
+// SetReplayIgnoreHeaders 对比时忽略的 header key
+func SetReplayIgnoreHeaders(keys ...string) ReplayOption {
+	return func(rp *Replayer) {
+		for _, k := range keys {
+			rp.ignore[k] = struct{}{}
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPollInterval = time.Second
+	_fileExt            = ".json"
+)
+
+type FileOption func(*File)
+
+// File 基于共享目录的注册中心, 每个实例一个文件: <dir>/<service>/<id>.json,
+// 多个进程共享同一目录即可互相发现, 不依赖外部服务; Watch 按 interval 轮询目录
+type File struct {
+	dir      string
+	interval time.Duration
+	ttl      time.Duration
+
+	mu sync.Mutex
+	// 设置 ttl 时续期 goroutine 的取消函数
+	renew map[string]func()
+}
+
+func NewFile(dir string, opts ...FileOption) *File {
+	f := &File{
+		dir:      dir,
+		interval: DefaultPollInterval,
+		renew:    make(map[string]func()),
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(f)
+		}
+	}
+	return f
+}
+
+func (f *File) path(service, key string) string {
+	return filepath.Join(f.dir, url.QueryEscape(service), url.QueryEscape(key)+_fileExt)
+}
+
+// Register 先写临时文件再 rename, 读方不会读到半个文件
+func (f *File) Register(ctx context.Context, ins Instance) error {
+	if ins.Service == "" {
+		return ErrNoService
+	}
+	bv, err := json.Marshal(ins)
+	if err != nil {
+		return err
+	}
+	path := f.path(ins.Service, ins.Key())
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(bv); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if f.ttl > 0 {
+		f.startRenew(path)
+	}
+	return nil
+}
+
+func (f *File) Deregister(ctx context.Context, ins Instance) error {
+	path := f.path(ins.Service, ins.Key())
+	f.mu.Lock()
+	if cancel, ok := f.renew[path]; ok {
+		cancel()
+		delete(f.renew, path)
+	}
+	f.mu.Unlock()
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// startRenew 定期更新文件修改时间, 进程异常退出后实例在 ttl 后失效
+func (f *File) startRenew(path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.renew[path]; ok {
+		return
+	}
+	stop := make(chan struct{})
+	f.renew[path] = func() { close(stop) }
+	go func() {
+		ticker := time.NewTicker(f.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				os.Chtimes(path, now, now)
+			}
+		}
+	}()
+}
+
+func (f *File) Lookup(ctx context.Context, service string) ([]Instance, error) {
+	if service == "" {
+		return nil, ErrNoService
+	}
+	dir := filepath.Join(f.dir, url.QueryEscape(service))
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Instance{}, nil
+		}
+		return nil, err
+	}
+	list := make([]Instance, 0, len(infos))
+	now := time.Now()
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, _fileExt) {
+			continue
+		}
+		if f.ttl > 0 && now.Sub(info.ModTime()) > f.ttl {
+			continue
+		}
+		bv, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			// 读取期间被注销
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		var ins Instance
+		if err = json.Unmarshal(bv, &ins); err != nil {
+			continue
+		}
+		list = append(list, ins)
+	}
+	return sortInstances(list), nil
+}
+
+func (f *File) Watch(ctx context.Context, service string) (<-chan []Instance, error) {
+	list, err := f.Lookup(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []Instance, 1)
+	ch <- list
+
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				cur, err := f.Lookup(ctx, service)
+				if err != nil || equalInstances(cur, list) {
+					continue
+				}
+				list = cur
+				push(ch, list)
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// SetPollInterval Watch 轮询目录的间隔
+func SetPollInterval(interval time.Duration) FileOption {
+	return func(f *File) {
+		if interval > 0 {
+			f.interval = interval
+		}
+	}
+}
+
+// SetTTL 实例文件超过 ttl 未续期即视为失效, 默认不过期;
+// 共享目录的所有进程须使用相同的 ttl
+func SetTTL(ttl time.Duration) FileOption {
+	return func(f *File) {
+		if ttl > 0 {
+			f.ttl = ttl
+		}
+	}
+}
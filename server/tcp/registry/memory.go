@@ -0,0 +1,106 @@
+package registry
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory 进程内注册中心, 用于测试及单进程部署
+type Memory struct {
+	mu       sync.Mutex
+	services map[string]map[string]Instance
+	watchers map[string]map[chan []Instance]struct{}
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		services: make(map[string]map[string]Instance),
+		watchers: make(map[string]map[chan []Instance]struct{}),
+	}
+}
+
+func (m *Memory) Register(ctx context.Context, ins Instance) error {
+	if ins.Service == "" {
+		return ErrNoService
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.services[ins.Service]
+	if !ok {
+		s = make(map[string]Instance)
+		m.services[ins.Service] = s
+	}
+	s[ins.Key()] = ins
+	m.notify(ins.Service)
+	return nil
+}
+
+func (m *Memory) Deregister(ctx context.Context, ins Instance) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.services[ins.Service]
+	if !ok {
+		return nil
+	}
+	if _, ok = s[ins.Key()]; !ok {
+		return nil
+	}
+	delete(s, ins.Key())
+	m.notify(ins.Service)
+	return nil
+}
+
+func (m *Memory) Lookup(ctx context.Context, service string) ([]Instance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.list(service), nil
+}
+
+func (m *Memory) Watch(ctx context.Context, service string) (<-chan []Instance, error) {
+	if service == "" {
+		return nil, ErrNoService
+	}
+	ch := make(chan []Instance, 1)
+	m.mu.Lock()
+	ws, ok := m.watchers[service]
+	if !ok {
+		ws = make(map[chan []Instance]struct{})
+		m.watchers[service] = ws
+	}
+	ws[ch] = struct{}{}
+	ch <- m.list(service)
+	m.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(ws, ch)
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// list 调用方须持有 m.mu
+func (m *Memory) list(service string) []Instance {
+	s := m.services[service]
+	list := make([]Instance, 0, len(s))
+	for _, ins := range s {
+		list = append(list, ins)
+	}
+	return sortInstances(list)
+}
+
+// notify 调用方须持有 m.mu
+func (m *Memory) notify(service string) {
+	ws := m.watchers[service]
+	if len(ws) == 0 {
+		return
+	}
+	list := m.list(service)
+	for ch := range ws {
+		push(ch, list)
+	}
+}
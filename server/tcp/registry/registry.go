@@ -0,0 +1,74 @@
+// Package registry Engine 实例的注册与发现
+package registry
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+var ErrNoService = errors.New("registry: empty service name")
+
+// Instance 一个 Engine 实例
+type Instance struct {
+	Service string `json:"service"`
+	// 实例唯一标识, 为空时使用 Addr
+	ID       string            `json:"id"`
+	Addr     string            `json:"addr"`
+	Weight   int               `json:"weight"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Key 实例在服务内的唯一标识
+func (ins Instance) Key() string {
+	if ins.ID != "" {
+		return ins.ID
+	}
+	return ins.Addr
+}
+
+// Registry 服务注册中心, Engine 启动时 Register, Stop 时 Deregister
+type Registry interface {
+	Register(ctx context.Context, ins Instance) error
+	Deregister(ctx context.Context, ins Instance) error
+	// Lookup 服务当前的全部实例
+	Lookup(ctx context.Context, service string) ([]Instance, error)
+	// Watch 先推送当前实例, 之后每次变化推送全量实例; ctx 结束后关闭 channel,
+	// 消费慢时只保留最新一次; 推送的实例只读
+	Watch(ctx context.Context, service string) (<-chan []Instance, error)
+}
+
+// sortInstances 按 Key 排序, 便于比较
+func sortInstances(list []Instance) []Instance {
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Key() < list[j].Key()
+	})
+	return list
+}
+
+func equalInstances(a, b []Instance) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key() != b[i].Key() || a[i].Addr != b[i].Addr || a[i].Weight != b[i].Weight ||
+			len(a[i].Metadata) != len(b[i].Metadata) {
+			return false
+		}
+		for k, v := range a[i].Metadata {
+			if bv, ok := b[i].Metadata[k]; !ok || bv != v {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// push 替换 channel 中未消费的旧值, 调用方须保证只有一个发送方
+func push(ch chan []Instance, list []Instance) {
+	select {
+	case <-ch:
+	default:
+	}
+	ch <- list
+}
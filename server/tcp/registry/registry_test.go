@@ -0,0 +1,156 @@
+package registry
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func registries(t *testing.T) map[string]Registry {
+	return map[string]Registry{
+		"memory": NewMemory(),
+		"file":   NewFile(t.TempDir(), SetPollInterval(10*time.Millisecond)),
+	}
+}
+
+// next 等待 Watch 推送满足 cond 的实例列表
+func next(t *testing.T, ch <-chan []Instance, cond func([]Instance) bool) []Instance {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case list, ok := <-ch:
+			if !ok {
+				t.Fatal("watch closed")
+			}
+			if cond(list) {
+				return list
+			}
+		case <-timeout:
+			t.Fatal("watch timeout")
+		}
+	}
+}
+
+func TestRegisterLookup(t *testing.T) {
+	ctx := context.Background()
+	for name, reg := range registries(t) {
+		a := Instance{Service: "svc", Addr: "127.0.0.1:1", Weight: 1, Metadata: map[string]string{"zone": "a"}}
+		b := Instance{Service: "svc", ID: "b", Addr: "127.0.0.1:2"}
+		for _, ins := range []Instance{a, b, a} {
+			if err := reg.Register(ctx, ins); err != nil {
+				t.Fatalf("%s: register: %v", name, err)
+			}
+		}
+		if err := reg.Register(ctx, Instance{Addr: "x"}); err != ErrNoService {
+			t.Fatalf("%s: want ErrNoService, got %v", name, err)
+		}
+
+		list, err := reg.Lookup(ctx, "svc")
+		if err != nil {
+			t.Fatalf("%s: lookup: %v", name, err)
+		}
+		if !equalInstances(list, sortInstances([]Instance{a, b})) {
+			t.Fatalf("%s: want [a b], got %+v", name, list)
+		}
+		if list, _ = reg.Lookup(ctx, "other"); len(list) != 0 {
+			t.Fatalf("%s: want no instances, got %+v", name, list)
+		}
+
+		if err = reg.Deregister(ctx, a); err != nil {
+			t.Fatalf("%s: deregister: %v", name, err)
+		}
+		// 重复注销不报错
+		if err = reg.Deregister(ctx, a); err != nil {
+			t.Fatalf("%s: deregister twice: %v", name, err)
+		}
+		if list, _ = reg.Lookup(ctx, "svc"); len(list) != 1 || list[0].Key() != "b" {
+			t.Fatalf("%s: want [b], got %+v", name, list)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	for name, reg := range registries(t) {
+		ctx, cancel := context.WithCancel(context.Background())
+		ch, err := reg.Watch(ctx, "svc")
+		if err != nil {
+			t.Fatalf("%s: watch: %v", name, err)
+		}
+		if list := <-ch; len(list) != 0 {
+			t.Fatalf("%s: want empty initial list, got %+v", name, list)
+		}
+
+		a := Instance{Service: "svc", Addr: "127.0.0.1:1"}
+		reg.Register(context.Background(), a)
+		next(t, ch, func(list []Instance) bool { return len(list) == 1 })
+		reg.Register(context.Background(), Instance{Service: "svc", Addr: "127.0.0.1:2"})
+		next(t, ch, func(list []Instance) bool { return len(list) == 2 })
+		reg.Deregister(context.Background(), a)
+		next(t, ch, func(list []Instance) bool { return len(list) == 1 && list[0].Addr == "127.0.0.1:2" })
+
+		cancel()
+		for range ch {
+		}
+	}
+}
+
+func TestFileTTL(t *testing.T) {
+	const ttl = 60 * time.Millisecond
+	dir := t.TempDir()
+	live := NewFile(dir, SetTTL(ttl))
+	crashed := NewFile(dir, SetTTL(ttl))
+	ctx := context.Background()
+
+	a := Instance{Service: "svc", Addr: "127.0.0.1:1"}
+	b := Instance{Service: "svc", Addr: "127.0.0.1:2"}
+	if err := live.Register(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	defer live.Deregister(ctx, a)
+	if err := crashed.Register(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+	// 模拟进程退出: 停止续期但不删除文件
+	crashed.mu.Lock()
+	for _, stop := range crashed.renew {
+		stop()
+	}
+	crashed.mu.Unlock()
+
+	if list, _ := live.Lookup(ctx, "svc"); len(list) != 2 {
+		t.Fatalf("want 2 instances before ttl, got %+v", list)
+	}
+	time.Sleep(3 * ttl)
+	list, err := live.Lookup(ctx, "svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Addr != a.Addr {
+		t.Fatalf("want only the renewed instance, got %+v", list)
+	}
+}
+
+func TestResolver(t *testing.T) {
+	reg := NewMemory()
+	reg.Register(context.Background(), Instance{Service: "svc", Addr: "127.0.0.1:1"})
+	r, err := NewResolver(reg, "svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if addrs := r.Addrs(); len(addrs) != 1 || addrs[0] != "127.0.0.1:1" {
+		t.Fatalf("want initial instance, got %v", addrs)
+	}
+
+	got := make(chan []Instance, 4)
+	r.Notify(func(list []Instance) {
+		got <- list
+	})
+	next(t, got, func(list []Instance) bool { return len(list) == 1 })
+	reg.Register(context.Background(), Instance{Service: "svc", Addr: "127.0.0.1:2"})
+	next(t, got, func(list []Instance) bool { return len(list) == 2 })
+	if len(r.Instances()) != 2 {
+		t.Fatalf("want 2 instances, got %+v", r.Instances())
+	}
+}
@@ -0,0 +1,85 @@
+package registry
+
+import (
+	"context"
+	"sync"
+)
+
+// Resolver 客户端持续跟踪服务的实例列表
+type Resolver struct {
+	service string
+	cancel  func()
+	done    chan struct{}
+
+	mu        sync.RWMutex
+	instances []Instance
+	// 回调串行执行
+	nmu    sync.Mutex
+	notify []func([]Instance)
+}
+
+// NewResolver 返回时已取得当前实例列表, Close 后停止跟踪
+func NewResolver(reg Registry, service string) (*Resolver, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := reg.Watch(ctx, service)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	r := &Resolver{
+		service:   service,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		instances: <-ch,
+	}
+	go r.loop(ch)
+	return r, nil
+}
+
+func (r *Resolver) loop(ch <-chan []Instance) {
+	defer close(r.done)
+	for list := range ch {
+		r.nmu.Lock()
+		r.mu.Lock()
+		r.instances = list
+		r.mu.Unlock()
+		for _, fn := range r.notify {
+			fn(list)
+		}
+		r.nmu.Unlock()
+	}
+}
+
+func (r *Resolver) Service() string {
+	return r.service
+}
+
+// Instances 当前实例列表, 只读
+func (r *Resolver) Instances() []Instance {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.instances
+}
+
+// Addrs 当前实例地址
+func (r *Resolver) Addrs() []string {
+	list := r.Instances()
+	addrs := make([]string, 0, len(list))
+	for _, ins := range list {
+		addrs = append(addrs, ins.Addr)
+	}
+	return addrs
+}
+
+// Notify 注册变化回调, 注册时以当前列表调用一次; 回调串行执行, 不可在回调中调用 Notify
+func (r *Resolver) Notify(fn func([]Instance)) {
+	r.nmu.Lock()
+	defer r.nmu.Unlock()
+	r.notify = append(r.notify, fn)
+	fn(r.Instances())
+}
+
+func (r *Resolver) Close() {
+	r.cancel()
+	<-r.done
+}
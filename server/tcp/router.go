@@ -1,9 +1,24 @@
 package tcp
 
+import (
+	"encoding/json"
+	"github.com/ousanki/sagittarius/core/code"
+	"hash/fnv"
+	"io"
+	"math"
+	"reflect"
+	"runtime"
+	"sort"
+	"time"
+)
+
 type Group struct {
 	cores []core
 	root  bool
+	name  string
 	svr   *Engine
+	// 处理链最长执行时间
+	timeout time.Duration
 }
 
 func (g *Group) Use(cores ...core) {
@@ -12,9 +27,11 @@ func (g *Group) Use(cores ...core) {
 
 func (g *Group) TcpGroup() *Group {
 	group := &Group{
-		svr:   g.svr,
-		root:  false,
-		cores: nil,
+		svr:     g.svr,
+		root:    false,
+		name:    g.name,
+		cores:   nil,
+		timeout: g.timeout,
 	}
 	if len(g.cores) > 0 {
 		group.cores = append(group.cores, g.cores...)
@@ -22,10 +39,187 @@ func (g *Group) TcpGroup() *Group {
 	return group
 }
 
+// Name 返回指定名称的子分组, 名称以 "." 拼接在父分组之后, 不修改原分组
+func (g *Group) Name(name string) *Group {
+	group := g.TcpGroup()
+	if g.name != "" {
+		name = g.name + "." + name
+	}
+	group.name = name
+	return group
+}
+
+// Timeout 返回限制处理链最长执行时间的子分组, 超时以 ErrTimeout 回包, 0 为不限制, 不修改原分组
+func (g *Group) Timeout(timeout time.Duration) *Group {
+	group := g.TcpGroup()
+	group.timeout = timeout
+	return group
+}
+
 func (g *Group) Invoke(id int64, cores ...core) {
+	g.add(&route{id: id, name: coreName(cores)}, cores...)
+}
+
+// MethodID 方法名映射为稳定的路由 ID (FNV-1a, 非负), 客户端按同样规则计算
+func MethodID(method string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(method))
+	return int64(h.Sum64() & math.MaxInt64)
+}
+
+// InvokeNamed 以方法名注册, 返回对应的路由 ID
+func (g *Group) InvokeNamed(method string, cores ...core) int64 {
+	id := MethodID(method)
+	g.add(&route{id: id, name: coreName(cores), method: method}, cores...)
+	return id
+}
+
+// Replace 运行时替换路由处理链, 不存在时新增; 保留原路由的方法名、请求/回包类型及流模式,
+// 替换强类型或流式路由并更新这些信息时使用 ReplaceHandle / ReplaceStream
+func (g *Group) Replace(id int64, cores ...core) {
+	g.svr.replaceCore(g.build(&route{id: id, name: coreName(cores)}, cores...))
+}
+
+func (g *Group) add(r *route, cores ...core) {
+	g.svr.addCore(g.build(r, cores...))
+}
+
+func (g *Group) build(r *route, cores ...core) *route {
 	var cs []core
 	cs = append(cs, g.cores...)
 	cs = append(cs, cores...)
 
-	g.svr.addCore(id, cs...)
+	r.group = g.name
+	r.timeout = g.timeout
+	r.cores = cs
+	if len(cs) > 0 {
+		r.middlewares = len(cs) - 1
+	}
+	return r
+}
+
+// ServerStream 注册服务端流
+func (g *Group) ServerStream(id int64, handler StreamHandler) {
+	g.stream(id, ServerStreaming, handler)
+}
+
+// ClientStream 注册客户端流
+func (g *Group) ClientStream(id int64, handler StreamHandler) {
+	g.stream(id, ClientStreaming, handler)
+}
+
+// BidiStream 注册双向流
+func (g *Group) BidiStream(id int64, handler StreamHandler) {
+	g.stream(id, BidiStreaming, handler)
+}
+
+// ReplaceStream 运行时替换为流式处理函数, 不存在时新增
+func (g *Group) ReplaceStream(id int64, mode StreamMode, handler StreamHandler) {
+	g.svr.replaceCore(g.build(streamRoute(id, mode, handler)))
+}
+
+func (g *Group) stream(id int64, mode StreamMode, handler StreamHandler) {
+	g.add(streamRoute(id, mode, handler))
+}
+
+func streamRoute(id int64, mode StreamMode, handler StreamHandler) (*route, core) {
+	r := &route{
+		id:     id,
+		name:   funcName(handler),
+		stream: mode.String(),
+	}
+	return r, func(c *Context) {
+		if !c.IsStream() {
+			genLogger.Write(c.Ctx(), "tcp route:%d only accept stream, err:%v", id, ErrNotStream)
+			// 回包避免调用方等待至超时
+			c.WriteError(c.ID(), code.BuildCode(ErrBadRequest.(*code.Error).Code, "route only accepts stream"))
+			return
+		}
+		s := newServerStream(c, mode)
+		if err := handler(s); err != nil {
+			s.Reset(err.Error())
+		}
+	}
+}
+
+type route struct {
+	id          int64
+	name        string
+	method      string
+	group       string
+	cores       []core
+	middlewares int
+	stream      string
+	request     reflect.Type
+	response    reflect.Type
+	timeout     time.Duration
+}
+
+// RouteInfo 路由表条目
+type RouteInfo struct {
+	ID          int64  `json:"id"`
+	Method      string `json:"method,omitempty"`
+	Name        string `json:"name"`
+	Group       string `json:"group,omitempty"`
+	Middlewares int    `json:"middlewares"`
+	Stream      string `json:"stream,omitempty"`
+	Request     string `json:"request,omitempty"`
+	Response    string `json:"response,omitempty"`
+	Timeout     string `json:"timeout,omitempty"`
+}
+
+func (r *route) info() RouteInfo {
+	ri := RouteInfo{
+		ID:          r.id,
+		Method:      r.method,
+		Name:        r.name,
+		Group:       r.group,
+		Middlewares: r.middlewares,
+		Stream:      r.stream,
+	}
+	if r.request != nil {
+		ri.Request = r.request.String()
+	}
+	if r.response != nil {
+		ri.Response = r.response.String()
+	}
+	if r.timeout > 0 {
+		ri.Timeout = r.timeout.String()
+	}
+	return ri
+}
+
+// Routes 按 ID 排序返回已注册路由
+func (s *Engine) Routes() []RouteInfo {
+	m := s.routes()
+	routes := make([]RouteInfo, 0, len(m))
+	for _, r := range m {
+		routes = append(routes, r.info())
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].ID < routes[j].ID
+	})
+	return routes
+}
+
+// ExportRoutes 以 JSON 导出路由表, 供客户端同步路由 ID
+func (s *Engine) ExportRoutes(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s.Routes())
+}
+
+func coreName(cores []core) string {
+	if len(cores) == 0 {
+		return ""
+	}
+	return funcName(cores[len(cores)-1])
+}
+
+func funcName(fn interface{}) string {
+	f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if f == nil {
+		return ""
+	}
+	return f.Name()
 }
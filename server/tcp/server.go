@@ -4,15 +4,24 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/gorilla/websocket"
 	"github.com/ousanki/sagittarius/core/log"
+	"github.com/ousanki/sagittarius/server/tcp/registry"
 	"io"
 	"net"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var genLogger *log.Logger
 
+var ErrServerClosed = errors.New("tcp: Server closed")
+
+// Accept 临时错误后的重试间隔
+const _acceptRetryDelay = 5 * time.Millisecond
+
 func init() {
 	genLogger = log.New("gen")
 	genLogger.WithOptions(
@@ -20,17 +29,39 @@ func init() {
 		log.SetPath("./log"),
 		log.SetFormat(log.ConsoleFormat),
 	)
+	// 登记后可由管理路由按名称调整级别
+	log.Register(genLogger)
 }
 
 type conn struct {
+	// 收到的帧数
+	frames     uint64
 	server     *Engine
 	c          net.Conn
 	ctx        context.Context
 	cancel     func()
 	remoteAddr string
+	accepted   time.Time
+	// 流量统计, 即 c
+	stat *statConn
+	// 写锁
+	wmu sync.Mutex
+	// 出站队列, 未启用时直接写连接
+	out *writeQueue
+	// 鉴权, authed 仅在读循环中读写; principal 可被 Session 在任意 goroutine 读取
+	authed    bool
+	pmu       sync.RWMutex
+	principal interface{}
+	// 对端是否使用紧凑 header
+	binaryHeader int32
+	// 流
+	smu     sync.Mutex
+	streams map[int64]*stream
 }
 
 func (c *conn) serve() {
+	defer c.shutdown()
+	defer c.abortStreams(io.ErrUnexpectedEOF)
 	for {
 		select {
 		case <-c.ctx.Done():
@@ -41,15 +72,59 @@ func (c *conn) serve() {
 				if err == io.ErrUnexpectedEOF || err == io.EOF {
 					return
 				}
-				genLogger.Write(ctx.Ctx(), "tcp conn read error, remote:%s, err:%v", c.remoteAddr, err)
+				genLogger.Write(c.ctx, "tcp conn read error, remote:%s, err:%v", c.remoteAddr, err)
+				if _, ok := err.(net.Error); ok || IsProtocolError(err) {
+					return
+				}
+			} else if !c.authed {
+				if !c.authenticate(ctx) {
+					return
+				}
+			} else if ctx.header.HasFlag(FlagStream) {
+				c.dispatchStream(ctx)
 			} else {
-				ctx.cores = c.server.findCore(ctx.header.GetID())
-				ctx.do()
+				ctx.bindRoute(c.server.findRoute(ctx.header.GetID()))
+				ctx.handle(c.server)
 			}
 		}
 	}
 }
 
+// writer 帧的写出目标
+func (c *conn) writer() io.Writer {
+	if c.out != nil {
+		return c.out
+	}
+	return c.c
+}
+
+// write 同一连接上的帧串行写出, 任意 goroutine 可并发调用
+func (c *conn) write(ctx context.Context, hb headerBase, sb streamBase, values map[string]interface{}, bv []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return writeRaw(ctx, hb, sb, values, bv, c.writer())
+}
+
+// shutdown 读结束后关闭连接, 出站队列中的帧在 _drainTimeout 内写出后由 flush 关闭
+func (c *conn) shutdown() {
+	if c.out == nil {
+		c.c.Close()
+		return
+	}
+	c.c.SetWriteDeadline(time.Now().Add(_drainTimeout))
+	c.out.close()
+}
+
+// flush 出站队列写出失败或慢连接被断开时关闭连接
+func (c *conn) flush() {
+	err := c.out.run(c.c)
+	if err != ErrConnClosed {
+		genLogger.Write(c.ctx, "tcp conn write error, remote:%s, err:%v", c.remoteAddr, err)
+	}
+	c.cancel()
+	c.c.Close()
+}
+
 type Engine struct {
 	*Group
 	Addr  string
@@ -57,15 +132,60 @@ type Engine struct {
 
 	mu         sync.Mutex
 	listener   net.Listener
+	packetConn net.PacketConn
 	activeConn map[*conn]struct{}
 	doneChan   chan struct{}
-	handlers   map[int64][]core
-	pool       sync.Pool
+	// 路由快照 map[int64]*route, 写时复制
+	handlers atomic.Value
+	pool     sync.Pool
+	// 协议
+	versions map[uint8]struct{}
+	checksum bool
+	// 单帧长度上限
+	maxFrameSize int64
+	// 流接收窗口及每个连接的流数上限
+	streamWindow int64
+	maxStreams   int
+	// websocket
+	upgrader websocket.Upgrader
+	// http 网关错误码到状态码的映射
+	httpStatusFn func(code int) int
+	// udp 会话超时及会话数上限
+	udpTimeout     time.Duration
+	udpMaxSessions int
+	// 出站队列
+	queueSize         int
+	queuePolicy       QueuePolicy
+	queueDropped      uint64
+	queueDisconnected uint64
+	// 出站队列统计注册到 metric 的名称
+	queueMetric string
+	// 回包是否回显请求 header
+	echoHeader bool
+	// 鉴权
+	auth        Authenticator
+	authTimeout time.Duration
+	// 服务注册
+	registry   registry.Registry
+	instance   registry.Instance
+	registered *registry.Instance
+	// 管理路由及其校验函数, 未开启时为 nil
+	admin      map[int64]*route
+	adminAllow func(*Context) bool
 }
 
+type Option func(*Engine)
+
 func NewApp(proto string) *Engine {
 	engine := &Engine{
-		Proto: proto,
+		Proto:          proto,
+		versions:       map[uint8]struct{}{ProtoVersion: {}},
+		maxFrameSize:   DefaultMaxFrameSize,
+		streamWindow:   DefaultStreamWindow,
+		maxStreams:     DefaultMaxStreams,
+		udpTimeout:     DefaultUDPSessionTimeout,
+		udpMaxSessions: DefaultUDPMaxSessions,
+		echoHeader:     true,
 	}
 	group := &Group{
 		svr:  engine,
@@ -78,9 +198,20 @@ func NewApp(proto string) *Engine {
 	return engine
 }
 
+func (s *Engine) WithOptions(opts ...Option) {
+	for _, opt := range opts {
+		if opt != nil {
+			opt(s)
+		}
+	}
+}
+
 func (s *Engine) Run(port string) error {
 	s.Addr = port
 	port = fmt.Sprintf("0.0.0.0:%s", s.Addr)
+	if isPacketProto(s.Proto) {
+		return s.runPacket(port)
+	}
 	addr, err := net.ResolveTCPAddr(s.Proto, port)
 	if err != nil {
 		return err
@@ -90,40 +221,80 @@ func (s *Engine) Run(port string) error {
 	if err != nil {
 		return err
 	}
-	s.listener = listener
-
 	return s.Serve(listener)
 }
 
+func (s *Engine) runPacket(port string) error {
+	addr, err := net.ResolveUDPAddr(s.Proto, port)
+	if err != nil {
+		return err
+	}
+	pc, err := net.ListenUDP(s.Proto, addr)
+	if err != nil {
+		return err
+	}
+	return s.ServePacket(pc)
+}
+
 func (s *Engine) Serve(l net.Listener) error {
-	baseCtx := context.Background()
+	s.mu.Lock()
+	s.listener = l
+	s.mu.Unlock()
+	if err := s.register(l.Addr()); err != nil {
+		l.Close()
+		return err
+	}
+	// Accept 出错返回时同样注销, 避免失效实例留在注册中心
+	defer s.deregister()
+	s.registerQueueMetric(l.Addr())
+	defer s.deregisterQueueMetric()
+
 	for {
 		c, err := l.Accept()
 		if err != nil {
 			select {
 			case <-s.getDoneChan():
-				return errors.New("tcp: Server closed")
+				return ErrServerClosed
+			default:
 			}
-			continue
-		}
-		ctx := context.WithValue(baseCtx, "accept", time.Now().Format("2006-01-02 15:04:05.000"))
-		ctx = context.WithValue(ctx, "remote", c.RemoteAddr().String())
-
-		ctx, fn := context.WithCancel(ctx)
-		cn := conn{
-			ctx:        ctx,
-			cancel:     fn,
-			c:          c,
-			server:     s,
-			remoteAddr: c.RemoteAddr().String(),
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(_acceptRetryDelay)
+				continue
+			}
+			return err
 		}
-		go func() {
-			s.trackConn(&cn, true)
-			defer s.trackConn(&cn, false)
+		go s.ServeConn(c)
+	}
+}
 
-			cn.serve()
-		}()
+// ServeConn 在已建立的连接上处理请求, 连接断开后返回
+func (s *Engine) ServeConn(c net.Conn) {
+	ctx := context.WithValue(context.Background(), "accept", time.Now().Format("2006-01-02 15:04:05.000"))
+	ctx = context.WithValue(ctx, "remote", c.RemoteAddr().String())
+
+	ctx, fn := context.WithCancel(ctx)
+	sc := &statConn{Conn: c}
+	cn := &conn{
+		ctx:        ctx,
+		cancel:     fn,
+		c:          sc,
+		stat:       sc,
+		server:     s,
+		remoteAddr: c.RemoteAddr().String(),
+		accepted:   time.Now(),
+		authed:     s.auth == nil,
+	}
+	if !cn.authed {
+		c.SetReadDeadline(time.Now().Add(s.authTimeout))
 	}
+	if s.queueSize > 0 {
+		cn.out = newWriteQueue(s)
+		go cn.flush()
+	}
+	s.trackConn(cn, true)
+	defer s.trackConn(cn, false)
+
+	cn.serve()
 }
 
 func (s *Engine) getDoneChan() <-chan struct{} {
@@ -136,15 +307,32 @@ func (s *Engine) getDoneChan() <-chan struct{} {
 	return s.doneChan
 }
 
+// Stop 先从注册中心注销, 再停止监听并取消全部连接
 func (s *Engine) Stop() {
+	s.deregister()
+	s.deregisterQueueMetric()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.doneChan == nil {
+		s.doneChan = make(chan struct{})
+	}
+	select {
+	case <-s.doneChan:
+	default:
+		close(s.doneChan)
+	}
+	if s.listener != nil {
+		s.listener.Close()
+	}
 	for conn, _ := range s.activeConn {
 		conn.cancel()
 		delete(s.activeConn, conn)
 	}
-	s.doneChan <- struct{}{}
+	if s.packetConn != nil {
+		s.packetConn.Close()
+	}
 }
 
 func (s *Engine) trackConn(c *conn, add bool) {
@@ -160,23 +348,214 @@ func (s *Engine) trackConn(c *conn, add bool) {
 	}
 }
 
-func (s *Engine) addCore(id int64, cores ...core) {
+// release 未进入处理链的 Context 归还对象池
+func (s *Engine) release(c *Context) {
+	c.reset()
+	s.pool.Put(c)
+}
+
+func (s *Engine) routes() map[int64]*route {
+	m, _ := s.handlers.Load().(map[int64]*route)
+	return m
+}
+
+// updateRoutes 复制当前快照修改后整体替换, 调用方需持有 s.mu
+func (s *Engine) updateRoutes(fn func(map[int64]*route)) {
+	old := s.routes()
+	m := make(map[int64]*route, len(old)+1)
+	for id, r := range old {
+		m[id] = r
+	}
+	fn(m)
+	s.handlers.Store(m)
+}
+
+func (s *Engine) addCore(r *route) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if reservedID(r.id) {
+		panic(fmt.Sprintf("server router id:%d is reserved", r.id))
+	}
+	if _, has := s.routes()[r.id]; has {
+		panic(fmt.Sprintf("server router id:%d already exist", r.id))
+	}
+	s.updateRoutes(func(m map[int64]*route) {
+		m[r.id] = r
+	})
+}
+
+// replaceCore 未指定的方法名沿用原路由; 以 Replace 注册的处理链沿用原路由的类型及流模式
+func (s *Engine) replaceCore(r *route) {
+	if reservedID(r.id) {
+		panic(fmt.Sprintf("server router id:%d is reserved", r.id))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old, has := s.routes()[r.id]; has {
+		if r.method == "" {
+			r.method = old.method
+		}
+		if r.request == nil && r.stream == "" {
+			r.request, r.response, r.stream = old.request, old.response, old.stream
+		}
+	}
+	s.updateRoutes(func(m map[int64]*route) {
+		m[r.id] = r
+	})
+}
+
+// Remove 运行时移除路由, 已在处理中的请求不受影响
+func (s *Engine) Remove(id int64) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if s.handlers == nil {
-		s.handlers = make(map[int64][]core)
+	if _, has := s.routes()[id]; !has {
+		return false
+	}
+	s.updateRoutes(func(m map[int64]*route) {
+		delete(m, id)
+	})
+	return true
+}
+
+func (s *Engine) findRoute(id int64) *route {
+	if id == PingID {
+		return _pingRoute
+	}
+	if r, ok := s.admin[id]; ok {
+		return r
+	}
+	return s.routes()[id]
+}
+
+// SetVersions 设置可接受的协议版本, 迁移期间可同时支持多个版本
+func SetVersions(versions ...uint8) Option {
+	return func(engine *Engine) {
+		if len(versions) == 0 {
+			return
+		}
+		engine.versions = make(map[uint8]struct{}, len(versions))
+		for _, v := range versions {
+			engine.versions[v] = struct{}{}
+		}
+	}
+}
+
+// SetChecksum 回包总是附带 CRC32, 否则仅在请求携带时附带
+func SetChecksum(checksum bool) Option {
+	return func(engine *Engine) {
+		engine.checksum = checksum
 	}
+}
+
+// SetMaxFrameSize 设置单帧 header 与 body 的长度上限, 超过时断开连接, 默认 DefaultMaxFrameSize
+func SetMaxFrameSize(size int64) Option {
+	return func(engine *Engine) {
+		if size > 0 {
+			engine.maxFrameSize = size
+		}
+	}
+}
 
-	if _, has := s.handlers[id]; has {
-		panic(fmt.Sprintf("server router id:%d already exist", id))
+// SetStreamWindow 设置每个流的接收窗口, 不小于 DefaultStreamWindow
+func SetStreamWindow(window int64) Option {
+	return func(engine *Engine) {
+		if window > DefaultStreamWindow {
+			engine.streamWindow = window
+		}
 	}
-	s.handlers[id] = cores
 }
 
-func (s *Engine) findCore(id int64) []core {
-	if _, has := s.handlers[id]; !has {
-		return nil
+// SetMaxStreams 设置每个连接同时打开的流数上限, 超过时 Reset 新流, 默认 DefaultMaxStreams
+func SetMaxStreams(n int) Option {
+	return func(engine *Engine) {
+		if n > 0 {
+			engine.maxStreams = n
+		}
+	}
+}
+
+// SetWebSocketOrigin 校验 WebSocket 请求来源, 默认仅允许同源
+func SetWebSocketOrigin(check func(r *http.Request) bool) Option {
+	return func(engine *Engine) {
+		engine.upgrader.CheckOrigin = check
+	}
+}
+
+// SetHTTPStatus 自定义 http 网关中错误码到 http 状态码的映射, 默认 DefaultHTTPStatus
+func SetHTTPStatus(fn func(code int) int) Option {
+	return func(engine *Engine) {
+		engine.httpStatusFn = fn
+	}
+}
+
+// SetUDPSessionTimeout 数据报模式下对端空闲超过 timeout 即释放会话
+func SetUDPSessionTimeout(timeout time.Duration) Option {
+	return func(engine *Engine) {
+		if timeout > 0 {
+			engine.udpTimeout = timeout
+		}
+	}
+}
+
+// SetUDPMaxSessions 数据报模式下同时存在的会话数上限, 超出时丢弃新对端的数据报, 默认 DefaultUDPMaxSessions
+func SetUDPMaxSessions(n int) Option {
+	return func(engine *Engine) {
+		if n > 0 {
+			engine.udpMaxSessions = n
+		}
+	}
+}
+
+// SetWriteQueue 启用出站队列, size 为每个连接可排队的帧数 (建议 DefaultWriteQueueSize),
+// policy 为队列满时的策略; 默认及 size 为 0 时同步写连接
+func SetWriteQueue(size int, policy QueuePolicy) Option {
+	return func(engine *Engine) {
+		if size >= 0 {
+			engine.queueSize = size
+			engine.queuePolicy = policy
+		}
+	}
+}
+
+// SetAuthenticator 启用连接鉴权, 连接须在 timeout 内以 AuthID 完成鉴权, 否则断开
+func SetAuthenticator(auth Authenticator, timeout time.Duration) Option {
+	return func(engine *Engine) {
+		engine.auth = auth
+		engine.authTimeout = timeout
+		if timeout <= 0 {
+			engine.authTimeout = DefaultAuthTimeout
+		}
+	}
+}
+
+// SetEchoHeader 回包是否回显请求 header, 默认开启; 关闭后仅回显 "_" 开头的保留 key,
+// 回包 header 通过 Context.SetResponseHeader 设置; http 网关始终不回显
+func SetEchoHeader(echo bool) Option {
+	return func(engine *Engine) {
+		engine.echoHeader = echo
+	}
+}
+
+// SetRegistry 开始服务时以 ins 注册, Stop 时注销; ins.Addr 为空时使用监听地址,
+// 监听在未指定地址上时使用本机 IP
+func SetRegistry(reg registry.Registry, ins registry.Instance) Option {
+	return func(engine *Engine) {
+		engine.registry = reg
+		engine.instance = ins
+	}
+}
+
+// SetAdmin 开启内置管理路由 (AdminConnsID 等), allow 返回 true 的请求才可调用, 为 nil 时关闭;
+// 如 SetAdmin(AdminLoopback) 只允许本机连接
+func SetAdmin(allow func(*Context) bool) Option {
+	return func(engine *Engine) {
+		engine.admin = nil
+		engine.adminAllow = allow
+		if allow != nil {
+			engine.admin = engine.adminRoutes()
+		}
 	}
-	return s.handlers[id]
 }
@@ -0,0 +1,68 @@
+package tcp
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+)
+
+// Session 服务端连接的句柄, 可在任意 goroutine 中向对端推送,
+// 与处理链中的回包及流式帧串行写出, 不会交错
+type Session struct {
+	c *conn
+}
+
+// Session 当前请求所在连接的句柄, 非 tcp 连接 (http 网关、udp) 返回 nil
+func (c *Context) Session() *Session {
+	if c.session == nil {
+		return nil
+	}
+	return &Session{c: c.session}
+}
+
+// Ctx 连接的 context, 断开后 Done
+func (s *Session) Ctx() context.Context {
+	return s.c.ctx
+}
+
+// Done 连接断开后关闭
+func (s *Session) Done() <-chan struct{} {
+	return s.c.ctx.Done()
+}
+
+func (s *Session) RemoteAddr() string {
+	return s.c.remoteAddr
+}
+
+// Write 以 id 推送一帧, header 可为 nil
+func (s *Session) Write(id int64, header map[string]interface{}, data interface{}) error {
+	select {
+	case <-s.c.ctx.Done():
+		return ErrConnClosed
+	default:
+	}
+	hb := headerBase{
+		Magic:     Magic,
+		Version:   ProtoVersion,
+		ID:        id,
+		WithTrace: UnUseTracer,
+	}
+	if s.c.server.checksum {
+		hb.Flag |= FlagChecksum
+	}
+	// 对端使用过紧凑 header 时推送同样使用
+	if atomic.LoadInt32(&s.c.binaryHeader) == 1 {
+		hb.Flag |= FlagBinaryHeader
+	}
+	bv, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return s.c.write(s.c.ctx, hb, streamBase{}, header, bv)
+}
+
+// Close 断开连接
+func (s *Session) Close() error {
+	s.c.cancel()
+	return s.c.c.Close()
+}
@@ -0,0 +1,92 @@
+package tcp_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/ousanki/sagittarius/server/tcp"
+	"github.com/ousanki/sagittarius/server/tcp/tcptest"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSessionConcurrentWrite go test -race, 处理函数回包与多个 goroutine 推送交错进行
+func TestSessionConcurrentWrite(t *testing.T) {
+	const (
+		pushers = 8
+		frames  = 50
+	)
+	for _, size := range []int{0, 16} {
+		e := tcp.NewApp("tcp")
+		e.WithOptions(tcp.SetWriteQueue(size, tcp.QueueBlock))
+		e.Invoke(1, func(c *tcp.Context) {
+			s := c.Session()
+			var wg sync.WaitGroup
+			for g := 0; g < pushers; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					for i := 0; i < frames; i++ {
+						s.Write(int64(100+g), map[string]interface{}{"i": i}, addResp{B: i})
+					}
+				}(g)
+			}
+			for i := 0; i < frames; i++ {
+				c.Write(1, addResp{B: i})
+			}
+			wg.Wait()
+		})
+		sc, cc := net.Pipe()
+		go e.ServeConn(sc)
+		if err := tcp.Write(context.Background(), tcp.UnUseTracer, nil, 1, addReq{}, cc); err != nil {
+			t.Fatal(err)
+		}
+
+		// 每个来源的帧按写出顺序到达
+		next := make(map[int64]int)
+		for n := 0; n < (pushers+1)*frames; n++ {
+			f, err := tcp.DecodeFrame(cc)
+			if err != nil {
+				t.Fatalf("queue %d frame %d: %v", size, n, err)
+			}
+			want := next[f.ID]
+			if string(f.Body) != fmt.Sprintf(`{"b":%d}`, want) {
+				t.Fatalf("queue %d id %d: want body %d, got %s", size, f.ID, want, f.Body)
+			}
+			next[f.ID]++
+		}
+		cc.Close()
+		e.Stop()
+	}
+}
+
+// TestSessionPrincipal go test -race, 推送 goroutine 读取鉴权身份
+func TestSessionPrincipal(t *testing.T) {
+	e := tcp.NewApp("tcp")
+	e.WithOptions(tcp.SetAuthenticator(tcp.AuthenticatorFunc(func(ctx context.Context, header map[string]interface{}, body []byte) (interface{}, error) {
+		return "user", nil
+	}), 0))
+	got := make(chan interface{}, 1)
+	e.Invoke(1, func(c *tcp.Context) {
+		s := c.Session()
+		go func() {
+			got <- s.Principal()
+		}()
+		c.Write(1, nil)
+	})
+	srv := tcptest.NewServer(e)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := srv.Client.Authenticate(ctx, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.Send(1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if p := <-got; p != "user" {
+		t.Fatalf("want principal user, got %v", p)
+	}
+}
@@ -0,0 +1,456 @@
+package tcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+)
+
+// 流帧类型
+const (
+	StreamOpen uint8 = iota
+	StreamData
+	// 半关闭
+	StreamEnd
+	StreamWindow
+	// 双向终止, body 为原因
+	StreamReset
+)
+
+type StreamMode int8
+
+const (
+	BidiStreaming StreamMode = iota
+	// 客户端一条请求, 服务端多条回包
+	ServerStreaming
+	// 客户端多条请求, 服务端一条回包
+	ClientStreaming
+)
+
+func (m StreamMode) String() string {
+	switch m {
+	case BidiStreaming:
+		return "bidi"
+	case ServerStreaming:
+		return "server"
+	case ClientStreaming:
+		return "client"
+	default:
+		return "unknown"
+	}
+}
+
+// DefaultStreamWindow 双方约定的初始窗口, 更大的窗口通过 StreamWindow 帧追加
+const DefaultStreamWindow int64 = 64 * 1024
+
+// DefaultMaxStreams 每个连接同时打开的流数上限
+const DefaultMaxStreams = 100
+
+const (
+	_streamChunkSize = 16 * 1024
+)
+
+var (
+	ErrNotStream      = errors.New("tcp: context is not a stream")
+	ErrFlowControl    = errors.New("tcp: stream flow control violated")
+	ErrStreamClosed   = errors.New("tcp: stream closed")
+	ErrStreamReset    = errors.New("tcp: stream reset")
+	ErrStreamMode     = errors.New("tcp: send not allowed in stream mode")
+	ErrTooManyStreams = errors.New("tcp: too many concurrent streams")
+)
+
+type streamBase struct {
+	StreamID int64
+	Kind     uint8
+	// StreamOpen/StreamWindow 帧追加的窗口额度
+	Window int64
+}
+
+// streamSender 发送一帧流数据
+type streamSender func(sb streamBase, payload []byte) error
+
+// stream 单个流的收发缓冲及流控
+type stream struct {
+	id     int64
+	window int64
+	send   streamSender
+	cancel func()
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// 接收
+	chunks   [][]byte
+	buffered int64
+	consumed int64
+	eof      bool
+	// 发送
+	credit int64
+	ended  bool
+	err    error
+}
+
+func newStream(id int64, window int64, send streamSender) *stream {
+	if window < DefaultStreamWindow {
+		window = DefaultStreamWindow
+	}
+	st := &stream{
+		id:     id,
+		window: window,
+		credit: DefaultStreamWindow,
+		send:   send,
+	}
+	st.cond = sync.NewCond(&st.mu)
+	return st
+}
+
+// announce 向对端追加超出初始窗口的额度
+func (st *stream) announce() error {
+	if extra := st.window - DefaultStreamWindow; extra > 0 {
+		return st.send(streamBase{StreamID: st.id, Kind: StreamWindow, Window: extra}, nil)
+	}
+	return nil
+}
+
+// push 收到数据帧
+func (st *stream) push(data []byte) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.buffered+int64(len(data)) > st.window {
+		st.err = ErrFlowControl
+		st.cond.Broadcast()
+		return ErrFlowControl
+	}
+	if len(data) > 0 {
+		st.chunks = append(st.chunks, data)
+		st.buffered += int64(len(data))
+	}
+	st.cond.Broadcast()
+	return nil
+}
+
+// finish 对端发送完毕
+func (st *stream) finish() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.eof = true
+	st.cond.Broadcast()
+}
+
+// grant 对端归还发送额度
+func (st *stream) grant(n int64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.credit += n
+	st.cond.Broadcast()
+}
+
+// abort 流异常终止, 唤醒所有等待方
+func (st *stream) abort(err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.err == nil {
+		st.err = err
+	}
+	st.cond.Broadcast()
+	if st.cancel != nil {
+		st.cancel()
+	}
+}
+
+// reset 终止本端并通知对端
+func (st *stream) reset(reason string) error {
+	st.abort(ErrStreamReset)
+	return st.send(streamBase{StreamID: st.id, Kind: StreamReset}, []byte(reason))
+}
+
+func (st *stream) Read(p []byte) (int, error) {
+	st.mu.Lock()
+	for len(st.chunks) == 0 && !st.eof && st.err == nil {
+		st.cond.Wait()
+	}
+	if len(st.chunks) == 0 {
+		err := st.err
+		if st.eof {
+			err = io.EOF
+		}
+		st.mu.Unlock()
+		return 0, err
+	}
+	var n int
+	for n < len(p) && len(st.chunks) > 0 {
+		m := copy(p[n:], st.chunks[0])
+		if m == len(st.chunks[0]) {
+			st.chunks = st.chunks[1:]
+		} else {
+			st.chunks[0] = st.chunks[0][m:]
+		}
+		n += m
+	}
+	st.buffered -= int64(n)
+	st.consumed += int64(n)
+	// 消费过半窗口后归还额度
+	var update int64
+	if !st.eof && st.consumed >= st.window/2 {
+		update = st.consumed
+		st.consumed = 0
+	}
+	st.mu.Unlock()
+
+	if update > 0 {
+		err := st.send(streamBase{StreamID: st.id, Kind: StreamWindow, Window: update}, nil)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (st *stream) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		st.mu.Lock()
+		for st.credit <= 0 && st.err == nil && !st.ended {
+			st.cond.Wait()
+		}
+		if st.err != nil {
+			err := st.err
+			st.mu.Unlock()
+			return written, err
+		}
+		if st.ended {
+			st.mu.Unlock()
+			return written, ErrStreamClosed
+		}
+		n := int64(len(p))
+		if n > st.credit {
+			n = st.credit
+		}
+		if n > _streamChunkSize {
+			n = _streamChunkSize
+		}
+		st.credit -= n
+		st.mu.Unlock()
+
+		err := st.send(streamBase{StreamID: st.id, Kind: StreamData}, p[:n])
+		if err != nil {
+			return written, err
+		}
+		written += int(n)
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close 半关闭, 对端读到 EOF
+func (st *stream) Close() error {
+	st.mu.Lock()
+	if st.ended {
+		st.mu.Unlock()
+		return nil
+	}
+	st.ended = true
+	err := st.err
+	st.cond.Broadcast()
+	st.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	return st.send(streamBase{StreamID: st.id, Kind: StreamEnd}, nil)
+}
+
+func (c *conn) streamSender(hb headerBase) streamSender {
+	return func(sb streamBase, payload []byte) error {
+		return c.write(c.ctx, hb, sb, nil, payload)
+	}
+}
+
+func (c *conn) dispatchStream(ctx *Context) {
+	sb := ctx.header.stream
+	if sb.Kind == StreamOpen {
+		c.openStream(ctx)
+		return
+	}
+	defer c.server.release(ctx)
+
+	c.smu.Lock()
+	st := c.streams[sb.StreamID]
+	c.smu.Unlock()
+	if st == nil {
+		genLogger.Write(c.ctx, "tcp stream not found, remote:%s, stream:%d", c.remoteAddr, sb.StreamID)
+		return
+	}
+	switch sb.Kind {
+	case StreamData:
+		if err := st.push(ctx.body.buf); err != nil {
+			genLogger.Write(c.ctx, "tcp stream push error, remote:%s, stream:%d, err:%v", c.remoteAddr, sb.StreamID, err)
+			st.reset(err.Error())
+			st.cancel()
+		}
+	case StreamEnd:
+		st.finish()
+	case StreamWindow:
+		// 非正的窗口会使额度失效, 视为违反流控
+		if sb.Window <= 0 {
+			genLogger.Write(c.ctx, "tcp stream bad window, remote:%s, stream:%d, window:%d", c.remoteAddr, sb.StreamID, sb.Window)
+			st.reset(ErrFlowControl.Error())
+			st.cancel()
+			return
+		}
+		st.grant(sb.Window)
+	case StreamReset:
+		st.abort(ErrStreamReset)
+	}
+}
+
+func (c *conn) openStream(ctx *Context) {
+	sb := ctx.header.stream
+	hb := headerBase{
+		Magic:   Magic,
+		Version: ctx.header.Version,
+		Flag:    FlagStream,
+		ID:      ctx.header.GetID(),
+	}
+	if ctx.header.HasFlag(FlagChecksum) || c.server.checksum {
+		hb.Flag |= FlagChecksum
+	}
+	if ctx.header.HasFlag(FlagBinaryHeader) {
+		hb.Flag |= FlagBinaryHeader
+	}
+	st := newStream(sb.StreamID, c.server.streamWindow, c.streamSender(hb))
+	// 流独立取消, 对端 Reset / 连接断开 / 处理函数返回时触发
+	ctx.ctx, st.cancel = context.WithCancel(ctx.ctx)
+
+	c.smu.Lock()
+	if c.streams == nil {
+		c.streams = make(map[int64]*stream)
+	}
+	if _, has := c.streams[sb.StreamID]; has {
+		c.smu.Unlock()
+		st.cancel()
+		c.server.release(ctx)
+		genLogger.Write(c.ctx, "tcp stream already open, remote:%s, stream:%d", c.remoteAddr, sb.StreamID)
+		return
+	}
+	// 超过上限的流直接 Reset, 避免对端无限打开流耗尽内存
+	if len(c.streams) >= c.server.maxStreams {
+		c.smu.Unlock()
+		genLogger.Write(c.ctx, "tcp stream limit reached, remote:%s, stream:%d, max:%d", c.remoteAddr, sb.StreamID, c.server.maxStreams)
+		st.reset(ErrTooManyStreams.Error())
+		st.cancel()
+		c.server.release(ctx)
+		return
+	}
+	// Open 帧的窗口为追加额度, 可为 0, 不可为负
+	if sb.Window < 0 {
+		c.smu.Unlock()
+		genLogger.Write(c.ctx, "tcp stream bad window, remote:%s, stream:%d, window:%d", c.remoteAddr, sb.StreamID, sb.Window)
+		st.reset(ErrFlowControl.Error())
+		st.cancel()
+		c.server.release(ctx)
+		return
+	}
+	c.streams[sb.StreamID] = st
+	c.smu.Unlock()
+
+	// Open 帧携带对端追加的窗口及首个数据块
+	st.grant(sb.Window)
+	st.push(ctx.body.buf)
+	if err := st.announce(); err != nil {
+		st.abort(err)
+	}
+
+	ctx.stream = st
+	ctx.bindRoute(c.server.findRoute(ctx.header.GetID()))
+	go func() {
+		defer c.closeStream(st)
+		if ctx.cancel != nil {
+			defer ctx.cancel()
+		}
+		ctx.do()
+	}()
+}
+
+// closeStream 处理函数返回后补发 End 并移除
+func (c *conn) closeStream(st *stream) {
+	st.Close()
+	st.cancel()
+
+	c.smu.Lock()
+	defer c.smu.Unlock()
+	delete(c.streams, st.id)
+}
+
+func (c *conn) abortStreams(err error) {
+	c.smu.Lock()
+	defer c.smu.Unlock()
+
+	for id, st := range c.streams {
+		st.abort(err)
+		delete(c.streams, id)
+	}
+}
+
+type StreamHandler func(*Stream) error
+
+// Stream 基于流的消息收发, 每条消息为一个 JSON 值
+type Stream struct {
+	*Context
+	mode  StreamMode
+	dec   *json.Decoder
+	enc   *json.Encoder
+	recvd int
+	sent  int
+}
+
+func newServerStream(c *Context, mode StreamMode) *Stream {
+	return &Stream{
+		Context: c,
+		mode:    mode,
+		dec:     json.NewDecoder(c.stream),
+		enc:     json.NewEncoder(c.stream),
+	}
+}
+
+func (s *Stream) Mode() StreamMode {
+	return s.mode
+}
+
+// Recv 读取下一条消息, 对端半关闭后返回 io.EOF
+func (s *Stream) Recv(v interface{}) error {
+	if s.mode == ServerStreaming && s.recvd > 0 {
+		return io.EOF
+	}
+	if err := s.dec.Decode(v); err != nil {
+		return err
+	}
+	s.recvd++
+	return nil
+}
+
+func (s *Stream) Send(v interface{}) error {
+	if s.mode == ClientStreaming && s.sent > 0 {
+		return ErrStreamMode
+	}
+	if err := s.enc.Encode(v); err != nil {
+		return err
+	}
+	s.sent++
+	return nil
+}
+
+// CloseSend 半关闭, 仍可继续 Recv
+func (s *Stream) CloseSend() error {
+	return s.stream.Close()
+}
+
+// Reset 终止流, 双方的 Ctx 均被取消
+func (s *Stream) Reset(reason string) error {
+	return s.stream.reset(reason)
+}
@@ -0,0 +1,148 @@
+package tcp
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+// nextReset 跳过其余帧, 返回首个 Reset 帧
+func nextReset(t *testing.T, c net.Conn) *Frame {
+	t.Helper()
+	for {
+		f, err := DecodeFrame(c)
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if f.IsStream() && f.StreamKind == StreamReset {
+			return f
+		}
+	}
+}
+
+func TestStreamBadWindow(t *testing.T) {
+	e := NewApp("tcp")
+	e.BidiStream(1, func(s *Stream) error {
+		var v interface{}
+		for s.Recv(&v) == nil {
+		}
+		return nil
+	})
+	e.Invoke(2, func(c *Context) {
+		c.Write(2, "ok")
+	})
+	sc, cc := net.Pipe()
+	defer cc.Close()
+	go e.ServeConn(sc)
+
+	hb := headerBase{Magic: Magic, Version: ProtoVersion, Flag: FlagStream, ID: 1}
+	errc := make(chan error, 1)
+	go func() {
+		ctx := context.Background()
+		// 重复打开的流被忽略, 连接继续可用
+		for _, sb := range []streamBase{
+			{StreamID: 1, Kind: StreamOpen},
+			{StreamID: 1, Kind: StreamOpen},
+			{StreamID: 1, Kind: StreamWindow, Window: -1},
+		} {
+			if err := writeRaw(ctx, hb, sb, nil, nil, cc); err != nil {
+				errc <- err
+				return
+			}
+		}
+		errc <- writeRaw(ctx, headerBase{Magic: Magic, Version: ProtoVersion, ID: 2}, streamBase{}, nil, nil, cc)
+	}()
+
+	if f := nextReset(t, cc); f.StreamID != 1 || string(f.Body) != ErrFlowControl.Error() {
+		t.Fatalf("want flow control reset on stream 1, got %d %q", f.StreamID, f.Body)
+	}
+	for {
+		f, err := DecodeFrame(cc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !f.IsStream() && f.ID == 2 {
+			break
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientStreamBadWindow(t *testing.T) {
+	sc, cc := net.Pipe()
+	defer sc.Close()
+	cl := NewClient(cc)
+	defer cl.Close()
+
+	opened := make(chan *ClientStream, 1)
+	go func() {
+		cs, err := cl.OpenStream(context.Background(), 1, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		opened <- cs
+	}()
+	open, err := DecodeFrame(sc)
+	if err != nil || open.StreamKind != StreamOpen {
+		t.Fatalf("want open frame, got %+v %v", open, err)
+	}
+	cs := <-opened
+	hb := headerBase{Magic: Magic, Version: ProtoVersion, Flag: FlagStream, ID: 1}
+	go writeRaw(context.Background(), hb, streamBase{StreamID: open.StreamID, Kind: StreamWindow}, nil, nil, sc)
+
+	if f := nextReset(t, sc); f.StreamID != open.StreamID || string(f.Body) != ErrFlowControl.Error() {
+		t.Fatalf("want flow control reset, got %d %q", f.StreamID, f.Body)
+	}
+	var v interface{}
+	if err = cs.Recv(&v); err == nil {
+		t.Fatal("want recv error after reset")
+	}
+}
+
+func TestStreamLimit(t *testing.T) {
+	e := NewApp("tcp")
+	e.WithOptions(SetMaxStreams(1))
+	release := make(chan struct{})
+	defer close(release)
+	e.BidiStream(1, func(s *Stream) error {
+		<-release
+		return nil
+	})
+	sc, cc := net.Pipe()
+	defer cc.Close()
+	go e.ServeConn(sc)
+
+	hb := headerBase{Magic: Magic, Version: ProtoVersion, Flag: FlagStream, ID: 1}
+	go func() {
+		ctx := context.Background()
+		writeRaw(ctx, hb, streamBase{StreamID: 1, Kind: StreamOpen}, nil, nil, cc)
+		writeRaw(ctx, hb, streamBase{StreamID: 2, Kind: StreamOpen}, nil, nil, cc)
+	}()
+	if f := nextReset(t, cc); f.StreamID != 2 || string(f.Body) != ErrTooManyStreams.Error() {
+		t.Fatalf("want stream limit reset on stream 2, got %d %q", f.StreamID, f.Body)
+	}
+}
+
+func TestStreamDataOverflow(t *testing.T) {
+	e := NewApp("tcp")
+	e.BidiStream(1, func(s *Stream) error {
+		<-s.Ctx().Done()
+		return nil
+	})
+	sc, cc := net.Pipe()
+	defer cc.Close()
+	go e.ServeConn(sc)
+
+	hb := headerBase{Magic: Magic, Version: ProtoVersion, Flag: FlagStream, ID: 1}
+	go func() {
+		ctx := context.Background()
+		writeRaw(ctx, hb, streamBase{StreamID: 1, Kind: StreamOpen}, nil, nil, cc)
+		// 超出接收窗口的数据帧
+		writeRaw(ctx, hb, streamBase{StreamID: 1, Kind: StreamData}, nil, make([]byte, DefaultStreamWindow+1), cc)
+	}()
+	if f := nextReset(t, cc); f.StreamID != 1 || string(f.Body) != ErrFlowControl.Error() {
+		t.Fatalf("want flow control reset on stream 1, got %d %q", f.StreamID, f.Body)
+	}
+}
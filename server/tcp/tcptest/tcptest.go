@@ -0,0 +1,260 @@
+// Package tcptest 在内存中启动 Engine, 用于处理函数的单元测试
+package tcptest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/opentracing/opentracing-go"
+	"github.com/ousanki/sagittarius/core/code"
+	"github.com/ousanki/sagittarius/server/tcp"
+	"github.com/uber/jaeger-client-go"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// DefaultTimeout Call / Send 的等待时长
+var DefaultTimeout = 5 * time.Second
+
+type Option func(*Server)
+
+// Server 测试用 Engine 及与其相连的客户端
+type Server struct {
+	Engine *tcp.Engine
+	Client *tcp.Client
+	// 回环模式下的监听地址, net.Pipe 模式为空
+	Addr string
+
+	loopback   bool
+	tracing    bool
+	clientOpts []tcp.ClientOption
+
+	listener   net.Listener
+	reporter   *jaeger.InMemoryReporter
+	tracer     opentracing.Tracer
+	closer     io.Closer
+	prevTracer opentracing.Tracer
+
+	mu      sync.Mutex
+	replies []*tcp.Reply
+}
+
+// NewServer 默认通过 net.Pipe 连接, 不占用端口
+func NewServer(engine *tcp.Engine, opts ...Option) *Server {
+	s := &Server{Engine: engine}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(s)
+		}
+	}
+
+	clientOpts := []tcp.ClientOption{tcp.SetClientOnReply(s.record)}
+	if s.tracing {
+		s.reporter = jaeger.NewInMemoryReporter()
+		s.tracer, s.closer = jaeger.NewTracer("tcptest", jaeger.NewConstSampler(true), s.reporter)
+		s.prevTracer = opentracing.GlobalTracer()
+		opentracing.SetGlobalTracer(s.tracer)
+		clientOpts = append(clientOpts, tcp.SetClientTrace(tcp.UseTracer))
+	}
+	clientOpts = append(clientOpts, s.clientOpts...)
+
+	if s.loopback {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			panic(fmt.Sprintf("tcptest: listen err:%v", err))
+		}
+		s.listener = l
+		s.Addr = l.Addr().String()
+		go engine.Serve(l)
+		cl, err := tcp.Dial(s.Addr, clientOpts...)
+		if err != nil {
+			panic(fmt.Sprintf("tcptest: dial err:%v", err))
+		}
+		s.Client = cl
+	} else {
+		sc, cc := net.Pipe()
+		go engine.ServeConn(sc)
+		s.Client = tcp.NewClient(cc, clientOpts...)
+	}
+	return s
+}
+
+func (s *Server) Close() {
+	s.Client.Close()
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	if s.tracing {
+		s.closer.Close()
+		opentracing.SetGlobalTracer(s.prevTracer)
+	}
+}
+
+// Call 以 DefaultTimeout 调用, 回包错误码转换为 code.Error
+func (s *Server) Call(id int64, req interface{}, resp interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	return s.Client.Call(ctx, id, req, resp)
+}
+
+// Send 携带 header 发送, 返回原始回包
+func (s *Server) Send(id int64, header map[string]interface{}, req interface{}) (*tcp.Reply, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	return s.Client.Send(ctx, id, header, req)
+}
+
+// SendContext 使用调用方的 ctx, 可携带 StartSpan 创建的 span
+func (s *Server) SendContext(ctx context.Context, id int64, header map[string]interface{}, req interface{}) (*tcp.Reply, error) {
+	return s.Client.Send(ctx, id, header, req)
+}
+
+func (s *Server) record(r *tcp.Reply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replies = append(s.replies, r)
+}
+
+// Replies 按到达顺序返回 Context.Write 写出的所有回包
+func (s *Server) Replies() []*tcp.Reply {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*tcp.Reply(nil), s.replies...)
+}
+
+func (s *Server) LastReply() *tcp.Reply {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.replies) == 0 {
+		return nil
+	}
+	return s.replies[len(s.replies)-1]
+}
+
+func (s *Server) ResetReplies() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replies = nil
+}
+
+// StartSpan 使用测试 tracer 创建 span, 需开启 SetTracing
+func (s *Server) StartSpan(name string) (context.Context, opentracing.Span) {
+	if s.tracer == nil {
+		panic("tcptest: tracing not enabled")
+	}
+	span := s.tracer.StartSpan(name)
+	return opentracing.ContextWithSpan(context.Background(), span), span
+}
+
+// FinishedSpans 已结束并上报的 span
+func (s *Server) FinishedSpans() []opentracing.Span {
+	if s.reporter == nil {
+		return nil
+	}
+	return s.reporter.GetSpans()
+}
+
+// TraceID 取 ctx 中 span 的 trace id, 没有 span 时取对端传入的 SpanContext, 供处理函数记录
+func TraceID(ctx context.Context) string {
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		return spanTraceID(span.Context())
+	}
+	if sc := tcp.RemoteSpanContext(ctx); sc != nil {
+		return spanTraceID(sc)
+	}
+	return ""
+}
+
+func spanTraceID(sc opentracing.SpanContext) string {
+	if jsc, ok := sc.(jaeger.SpanContext); ok {
+		return jsc.TraceID().String()
+	}
+	return ""
+}
+
+// AssertCode 断言 err 为指定错误码的 code.Error
+func AssertCode(t testing.TB, err error, want int) {
+	t.Helper()
+	e, ok := code.FromError(err)
+	if !ok {
+		t.Fatalf("tcptest: want code %d, got err %v", want, err)
+	}
+	if e.Code != want {
+		t.Fatalf("tcptest: want code %d, got %d (%s)", want, e.Code, e.Message)
+	}
+}
+
+// AssertHeader 断言回包 header 值, want 与实际值均按 JSON 编解码后比较
+func AssertHeader(t testing.TB, r *tcp.Reply, key string, want interface{}) {
+	t.Helper()
+	if r == nil {
+		t.Fatalf("tcptest: nil reply")
+	}
+	got, ok := r.Header[key]
+	if !ok {
+		t.Fatalf("tcptest: header %q missing", key)
+	}
+	norm, err := normalize(want)
+	if err != nil {
+		t.Fatalf("tcptest: marshal want err:%v", err)
+	}
+	// 紧凑 header 中整数解码为 int64
+	if got, err = normalize(got); err != nil {
+		t.Fatalf("tcptest: marshal header err:%v", err)
+	}
+	if !reflect.DeepEqual(got, norm) {
+		t.Fatalf("tcptest: header %q want %v, got %v", key, norm, got)
+	}
+}
+
+func normalize(v interface{}) (interface{}, error) {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var norm interface{}
+	err = json.Unmarshal(bs, &norm)
+	return norm, err
+}
+
+// AssertTraced 断言 traceID 与 span 同属一条 trace
+func AssertTraced(t testing.TB, span opentracing.Span, traceID string) {
+	t.Helper()
+	want := spanTraceID(span.Context())
+	if want == "" || want != traceID {
+		t.Fatalf("tcptest: want trace %q, got %q", want, traceID)
+	}
+}
+
+// AssertReplyTraced 断言回包携带的 trace 与 span 一致
+func AssertReplyTraced(t testing.TB, span opentracing.Span, r *tcp.Reply) {
+	t.Helper()
+	if r == nil || r.Span == nil {
+		t.Fatalf("tcptest: reply carries no trace")
+	}
+	AssertTraced(t, span, spanTraceID(r.Span))
+}
+
+// SetLoopback 使用 127.0.0.1 随机端口代替 net.Pipe
+func SetLoopback() Option {
+	return func(s *Server) {
+		s.loopback = true
+	}
+}
+
+// SetTracing 安装记录 span 的全局 tracer, 客户端请求携带 trace
+func SetTracing() Option {
+	return func(s *Server) {
+		s.tracing = true
+	}
+}
+
+func SetClientOptions(opts ...tcp.ClientOption) Option {
+	return func(s *Server) {
+		s.clientOpts = append(s.clientOpts, opts...)
+	}
+}
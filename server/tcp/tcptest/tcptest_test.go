@@ -0,0 +1,166 @@
+package tcptest_test
+
+import (
+	"github.com/opentracing/opentracing-go"
+	"github.com/ousanki/sagittarius/core/code"
+	"github.com/ousanki/sagittarius/server/tcp"
+	"github.com/ousanki/sagittarius/server/tcp/tcptest"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+type echoReq struct {
+	A int `json:"a"`
+}
+
+type echoResp struct {
+	A int `json:"a"`
+}
+
+func newEngine() *tcp.Engine {
+	e := tcp.NewApp("tcp")
+	e.Handle(1, func(c *tcp.Context, req echoReq) (echoResp, error) {
+		c.SetResponseHeader("list", []string{"a", "b"})
+		return echoResp{A: req.A}, nil
+	})
+	e.Handle(2, func(c *tcp.Context, req echoReq) (echoResp, error) {
+		return echoResp{}, code.BuildCode(1001, "bad a")
+	})
+	e.Invoke(3, func(c *tcp.Context) {
+		// 回包携带 trace 供 AssertReplyTraced 校验
+		c.WithTrace(tcp.UseTracer)
+		c.Write(3, tcptest.TraceID(c.Ctx()))
+	})
+	return e
+}
+
+// fakeTB 记录 Fatalf, 用于断言辅助函数的失败分支
+type fakeTB struct {
+	testing.TB
+	failed bool
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Fatalf(format string, args ...interface{}) {
+	f.failed = true
+	runtime.Goexit()
+}
+
+func fails(fn func(tb testing.TB)) bool {
+	tb := &fakeTB{}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		fn(tb)
+	}()
+	wg.Wait()
+	return tb.failed
+}
+
+func TestCall(t *testing.T) {
+	for _, opts := range [][]tcptest.Option{nil, {tcptest.SetLoopback()}} {
+		srv := tcptest.NewServer(newEngine(), opts...)
+		var resp echoResp
+		if err := srv.Call(1, echoReq{A: 7}, &resp); err != nil || resp.A != 7 {
+			t.Fatalf("want 7, got %v %v", resp.A, err)
+		}
+		err := srv.Call(2, echoReq{}, nil)
+		tcptest.AssertCode(t, err, 1001)
+		if !fails(func(tb testing.TB) { tcptest.AssertCode(tb, err, 1002) }) {
+			t.Fatal("AssertCode accepted a wrong code")
+		}
+		if !fails(func(tb testing.TB) { tcptest.AssertCode(tb, nil, 1001) }) {
+			t.Fatal("AssertCode accepted a nil error")
+		}
+		srv.Close()
+	}
+}
+
+func TestSendAndAssertHeader(t *testing.T) {
+	srv := tcptest.NewServer(newEngine())
+	defer srv.Close()
+
+	r, err := srv.Send(1, map[string]interface{}{"uid": 42}, echoReq{A: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcptest.AssertHeader(t, r, "uid", 42)
+	tcptest.AssertHeader(t, r, "list", []string{"a", "b"})
+	for name, fn := range map[string]func(tb testing.TB){
+		"wrong value": func(tb testing.TB) { tcptest.AssertHeader(tb, r, "uid", 43) },
+		"missing":     func(tb testing.TB) { tcptest.AssertHeader(tb, r, "none", 1) },
+		"nil reply":   func(tb testing.TB) { tcptest.AssertHeader(tb, nil, "uid", 42) },
+	} {
+		if !fails(fn) {
+			t.Fatalf("%s: AssertHeader did not fail", name)
+		}
+	}
+
+	if got := srv.LastReply(); got == nil || got.ID != 1 {
+		t.Fatalf("want last reply for id 1, got %+v", got)
+	}
+	srv.Send(2, nil, echoReq{})
+	if n := len(srv.Replies()); n != 2 {
+		t.Fatalf("want 2 replies, got %d", n)
+	}
+	srv.ResetReplies()
+	if srv.LastReply() != nil || len(srv.Replies()) != 0 {
+		t.Fatal("replies not reset")
+	}
+}
+
+func TestTracing(t *testing.T) {
+	srv := tcptest.NewServer(newEngine(), tcptest.SetTracing())
+	ctx, span := srv.StartSpan("client")
+	r, err := srv.SendContext(ctx, 3, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	span.Finish()
+
+	var traceID string
+	if err = r.Decode(&traceID); err != nil {
+		t.Fatal(err)
+	}
+	tcptest.AssertTraced(t, span, traceID)
+	tcptest.AssertReplyTraced(t, span, r)
+	if tcptest.TraceID(ctx) != traceID {
+		t.Fatalf("want trace %s from ctx, got %s", traceID, tcptest.TraceID(ctx))
+	}
+	if !fails(func(tb testing.TB) { tcptest.AssertTraced(tb, span, "") }) {
+		t.Fatal("AssertTraced accepted an empty trace")
+	}
+	if !fails(func(tb testing.TB) { tcptest.AssertReplyTraced(tb, span, &tcp.Reply{}) }) {
+		t.Fatal("AssertReplyTraced accepted a reply without trace")
+	}
+
+	found := false
+	for _, s := range srv.FinishedSpans() {
+		if s == span {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("finished span not reported")
+	}
+
+	prev := opentracing.GlobalTracer()
+	srv.Close()
+	if opentracing.GlobalTracer() == prev {
+		t.Fatal("global tracer not restored")
+	}
+}
+
+func TestStartSpanWithoutTracing(t *testing.T) {
+	srv := tcptest.NewServer(newEngine())
+	defer srv.Close()
+	defer func() {
+		if recover() == nil {
+			t.Fatal("want panic without SetTracing")
+		}
+	}()
+	srv.StartSpan("x")
+}
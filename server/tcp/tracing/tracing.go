@@ -0,0 +1,171 @@
+// Package tracing 为每个请求创建服务端 span 的中间件
+package tracing
+
+import (
+	"fmt"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/ousanki/sagittarius/server/tcp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+)
+
+const _instrumentation = "github.com/ousanki/sagittarius/server/tcp"
+
+// span tag
+const (
+	TagRouteID      = "rpc.route_id"
+	TagMethod       = "rpc.method"
+	TagRemote       = "net.peer.address"
+	TagRequestSize  = "rpc.request.size"
+	TagResponseSize = "rpc.response.size"
+	TagCode         = "rpc.code"
+	TagStream       = "rpc.stream"
+)
+
+type Option func(*Tracing)
+
+// Tracing 中间件, 通过 Group.Use 安装, 处理链结束后 span 结束.
+// 默认使用 opentracing 全局 tracer, 父 span 为请求以 UseTracer 携带的 SpanContext;
+// 设置 SetTracerProvider 后使用 OpenTelemetry, 父 span 为 UseTraceContext 携带的 traceparent
+type Tracing struct {
+	tracer   opentracing.Tracer
+	provider trace.TracerProvider
+	otel     trace.Tracer
+}
+
+func New(opts ...Option) *Tracing {
+	t := &Tracing{}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(t)
+		}
+	}
+	if t.provider != nil {
+		t.otel = t.provider.Tracer(_instrumentation)
+	}
+	return t
+}
+
+// result 处理链的回包结果, 超时回包可能与处理链并发写入
+type result struct {
+	mu      sync.Mutex
+	size    int
+	code    int
+	message string
+}
+
+func (r *result) get() (int, int, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.size, r.code, r.message
+}
+
+func (t *Tracing) Handle(c *tcp.Context) {
+	res := new(result)
+	c.OnWrite(func(id int64, header map[string]interface{}, body []byte) {
+		res.mu.Lock()
+		defer res.mu.Unlock()
+		res.size += len(body)
+		if e := tcp.HeaderError(header); e != nil && res.code == 0 {
+			res.code = e.Code
+			res.message = e.Message
+		}
+	})
+	if t.otel != nil {
+		t.handleOTel(c, res)
+		return
+	}
+	t.handleOpentracing(c, res)
+}
+
+func (t *Tracing) handleOpentracing(c *tcp.Context, res *result) {
+	tracer := t.tracer
+	if tracer == nil {
+		tracer = opentracing.GlobalTracer()
+	}
+	opts := []opentracing.StartSpanOption{ext.SpanKindRPCServer}
+	if parent := tcp.RemoteSpanContext(c.Ctx()); parent != nil {
+		opts = append(opts, ext.RPCServerOption(parent))
+	} else if span := opentracing.SpanFromContext(c.Ctx()); span != nil {
+		opts = append(opts, opentracing.ChildOf(span.Context()))
+	}
+	span := tracer.StartSpan(operationName(c), opts...)
+	defer span.Finish()
+	c.SetCtx(opentracing.ContextWithSpan(c.Ctx(), span))
+
+	span.SetTag(TagRouteID, c.ID())
+	if c.Method() != "" {
+		span.SetTag(TagMethod, c.Method())
+	}
+	if remote, ok := c.Ctx().Value("remote").(string); ok {
+		span.SetTag(TagRemote, remote)
+	}
+	span.SetTag(TagStream, c.IsStream())
+	span.SetTag(TagRequestSize, len(c.Body()))
+
+	c.Next()
+
+	size, code, message := res.get()
+	span.SetTag(TagResponseSize, size)
+	span.SetTag(TagCode, code)
+	if code != 0 {
+		ext.Error.Set(span, true)
+		span.LogKV("event", "error", "code", code, "message", message)
+	}
+}
+
+func (t *Tracing) handleOTel(c *tcp.Context, res *result) {
+	attrs := []attribute.KeyValue{
+		attribute.Int64(TagRouteID, c.ID()),
+		attribute.Bool(TagStream, c.IsStream()),
+		attribute.Int(TagRequestSize, len(c.Body())),
+	}
+	if c.Method() != "" {
+		attrs = append(attrs, attribute.String(TagMethod, c.Method()))
+	}
+	if remote, ok := c.Ctx().Value("remote").(string); ok {
+		attrs = append(attrs, attribute.String(TagRemote, remote))
+	}
+	ctx, span := t.otel.Start(c.Ctx(), operationName(c),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...),
+	)
+	defer span.End()
+	c.SetCtx(ctx)
+
+	c.Next()
+
+	size, code, message := res.get()
+	span.SetAttributes(
+		attribute.Int(TagResponseSize, size),
+		attribute.Int(TagCode, code),
+	)
+	if code != 0 {
+		span.SetStatus(codes.Error, message)
+	}
+}
+
+// operationName 优先使用方法名
+func operationName(c *tcp.Context) string {
+	if c.Method() != "" {
+		return c.Method()
+	}
+	return fmt.Sprintf("%d", c.ID())
+}
+
+// SetTracer 使用指定的 opentracing tracer, 默认为调用时的全局 tracer
+func SetTracer(tracer opentracing.Tracer) Option {
+	return func(t *Tracing) {
+		t.tracer = tracer
+	}
+}
+
+// SetTracerProvider 使用 OpenTelemetry 创建 span
+func SetTracerProvider(provider trace.TracerProvider) Option {
+	return func(t *Tracing) {
+		t.provider = provider
+	}
+}
@@ -0,0 +1,125 @@
+package tracing_test
+
+import (
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/ousanki/sagittarius/core/code"
+	"github.com/ousanki/sagittarius/server/tcp"
+	"github.com/ousanki/sagittarius/server/tcp/tcptest"
+	"github.com/ousanki/sagittarius/server/tcp/tracing"
+	"github.com/uber/jaeger-client-go"
+	"testing"
+	"time"
+)
+
+type addReq struct {
+	A int `json:"a"`
+}
+
+type addResp struct {
+	B int `json:"b"`
+}
+
+func newServer() (*tcptest.Server, int64, int64) {
+	e := tcp.NewApp("tcp")
+	e.Use(tracing.New().Handle)
+	ok := e.HandleNamed("svc.Add", func(c *tcp.Context, req addReq) (addResp, error) {
+		// 处理函数可取到服务端 span
+		if opentracing.SpanFromContext(c.Ctx()) == nil {
+			return addResp{}, code.BuildCode(1002, "no span")
+		}
+		return addResp{B: req.A + 1}, nil
+	})
+	fail := e.HandleNamed("svc.Fail", func(c *tcp.Context, req addReq) (addResp, error) {
+		return addResp{}, code.BuildCode(1001, "bad a")
+	})
+	return tcptest.NewServer(e, tcptest.SetTracing()), ok, fail
+}
+
+// serverSpan 等待处理链结束后上报的服务端 span
+func serverSpan(t *testing.T, srv *tcptest.Server, name string) *jaeger.Span {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		for _, s := range srv.FinishedSpans() {
+			if js := s.(*jaeger.Span); js.OperationName() == name {
+				return js
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("span %s not finished", name)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestServerSpan(t *testing.T) {
+	srv, id, _ := newServer()
+	defer srv.Close()
+
+	ctx, parent := srv.StartSpan("client")
+	r, err := srv.SendContext(ctx, id, nil, addReq{A: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Err(); err != nil {
+		t.Fatal(err)
+	}
+	parent.Finish()
+
+	span := serverSpan(t, srv, "svc.Add")
+	psc := parent.Context().(jaeger.SpanContext)
+	if span.SpanContext().TraceID() != psc.TraceID() || span.SpanContext().ParentID() != psc.SpanID() {
+		t.Fatalf("want child of %v, got %v", psc, span.SpanContext())
+	}
+	tags := span.Tags()
+	want := map[string]interface{}{
+		tracing.TagRouteID:     id,
+		tracing.TagMethod:      "svc.Add",
+		tracing.TagStream:      false,
+		tracing.TagRequestSize: len(`{"a":1}`),
+		tracing.TagCode:        0,
+		string(ext.SpanKind):   ext.SpanKindRPCServerEnum,
+	}
+	for k, v := range want {
+		if tags[k] != v {
+			t.Fatalf("tag %s: want %v, got %v", k, v, tags[k])
+		}
+	}
+	if n, _ := tags[tracing.TagResponseSize].(int); n != len(r.Body) {
+		t.Fatalf("want response size %d, got %v", len(r.Body), tags[tracing.TagResponseSize])
+	}
+	if _, ok := tags[string(ext.Error)]; ok {
+		t.Fatal("error tag on a successful span")
+	}
+}
+
+func TestServerSpanError(t *testing.T) {
+	srv, _, id := newServer()
+	defer srv.Close()
+
+	ctx, parent := srv.StartSpan("client")
+	r, err := srv.SendContext(ctx, id, nil, addReq{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent.Finish()
+	tcptest.AssertCode(t, r.Err(), 1001)
+
+	span := serverSpan(t, srv, "svc.Fail")
+	tags := span.Tags()
+	if tags[string(ext.Error)] != true || tags[tracing.TagCode] != 1001 {
+		t.Fatalf("want error status with code 1001, got %v", tags)
+	}
+	logs := span.Logs()
+	if len(logs) != 1 {
+		t.Fatalf("want one error log, got %v", logs)
+	}
+	fields := make(map[string]interface{})
+	for _, f := range logs[0].Fields {
+		fields[f.Key()] = f.Value()
+	}
+	if fields["event"] != "error" || fields["message"] != "bad a" {
+		t.Fatalf("unexpected error log %v", fields)
+	}
+}
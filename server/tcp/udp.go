@@ -0,0 +1,326 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/ousanki/sagittarius/core/code"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 单个 UDP 数据报上限, 即 IPv4 下 UDP 的最大载荷 (65535 - 20 - 8)
+	MaxDatagramSize = 65507
+	// 对端无数据报超过该时长即释放会话
+	DefaultUDPSessionTimeout = time.Minute
+	// 同时存在的会话数上限, 超出时丢弃新对端的数据报
+	DefaultUDPMaxSessions = 10000
+	// 每个会话排队等待处理的数据报数, 超出丢弃
+	_udpQueueSize = 128
+	// 尚未解码出有效帧的会话的空闲上限, 伪造源地址的数据报不会长期占用会话
+	_udpPendingTimeout = 5 * time.Second
+)
+
+var ErrDatagramTooLarge = errors.New("tcp: frame exceeds datagram size")
+
+// isPacketProto 是否为数据报协议
+func isPacketProto(proto string) bool {
+	return strings.HasPrefix(proto, "udp")
+}
+
+// ServePacket 以数据报模式处理请求, 每个数据报为一个完整的帧,
+// 按对端地址建立伪会话, 同一对端的请求顺序处理; 不支持流式请求
+func (s *Engine) ServePacket(pc net.PacketConn) error {
+	s.mu.Lock()
+	s.packetConn = pc
+	s.mu.Unlock()
+	if err := s.register(pc.LocalAddr()); err != nil {
+		pc.Close()
+		return err
+	}
+	defer s.deregister()
+
+	sessions := make(map[string]*udpSession)
+	var mu sync.Mutex
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for key, us := range sessions {
+			us.cancel()
+			delete(sessions, key)
+		}
+	}()
+
+	// 过期会话回收, 未解码出有效帧的会话以 _udpPendingTimeout 为空闲上限
+	pending := s.udpTimeout
+	if pending > _udpPendingTimeout {
+		pending = _udpPendingTimeout
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(pending / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				mu.Lock()
+				for key, us := range sessions {
+					idle := s.udpTimeout
+					if !us.isActive() {
+						idle = pending
+					}
+					if now.Sub(us.lastSeen()) > idle {
+						us.cancel()
+						delete(sessions, key)
+					}
+				}
+				mu.Unlock()
+			}
+		}
+	}()
+
+	buf := make([]byte, MaxDatagramSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-s.getDoneChan():
+				return ErrServerClosed
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+		data := make([]byte, n)
+		copy(data, buf[:n])
+
+		key := addr.String()
+		mu.Lock()
+		us := sessions[key]
+		if us == nil {
+			if len(sessions) >= s.udpMaxSessions {
+				mu.Unlock()
+				genLogger.Write(context.Background(), "udp session limit reached, remote:%s, max:%d", key, s.udpMaxSessions)
+				continue
+			}
+			us = s.newUDPSession(pc, addr)
+			sessions[key] = us
+		}
+		mu.Unlock()
+		us.push(data)
+	}
+}
+
+// udpSession 对端的伪会话, 作为 Context 的 net.Conn, 每次 Write 为一个数据报
+type udpSession struct {
+	server *Engine
+	pc     net.PacketConn
+	addr   net.Addr
+	ctx    context.Context
+	cancel func()
+	queue  chan []byte
+
+	mu   sync.Mutex
+	seen time.Time
+	// 已解码出有效帧
+	active int32
+	// 鉴权, 仅在 serve goroutine 中读写
+	authed    bool
+	principal interface{}
+}
+
+func (s *Engine) newUDPSession(pc net.PacketConn, addr net.Addr) *udpSession {
+	ctx := context.WithValue(context.Background(), "accept", time.Now().Format("2006-01-02 15:04:05.000"))
+	ctx = context.WithValue(ctx, "remote", addr.String())
+	ctx, fn := context.WithCancel(ctx)
+	us := &udpSession{
+		server: s,
+		pc:     pc,
+		addr:   addr,
+		ctx:    ctx,
+		cancel: fn,
+		queue:  make(chan []byte, _udpQueueSize),
+		seen:   time.Now(),
+		authed: s.auth == nil,
+	}
+	go us.serve()
+	return us
+}
+
+func (us *udpSession) push(data []byte) {
+	us.mu.Lock()
+	us.seen = time.Now()
+	us.mu.Unlock()
+	select {
+	case us.queue <- data:
+	default:
+		genLogger.Write(us.ctx, "udp session queue full, remote:%s, drop:%d", us.addr, len(data))
+	}
+}
+
+func (us *udpSession) lastSeen() time.Time {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	return us.seen
+}
+
+func (us *udpSession) isActive() bool {
+	return atomic.LoadInt32(&us.active) == 1
+}
+
+func (us *udpSession) serve() {
+	for {
+		select {
+		case <-us.ctx.Done():
+			return
+		case data := <-us.queue:
+			us.handle(data)
+		}
+	}
+}
+
+// handle 解码失败只丢弃当前数据报, 会话继续可用;
+// header 与 body 长度以数据报大小为上限, 鉴权前的数据报也不会按声明的长度分配内存
+func (us *udpSession) handle(data []byte) {
+	s := us.server
+	h, b, spCtx, err := readFrame(bytes.NewReader(data), s.versions, int64(len(data)))
+	if err != nil {
+		genLogger.Write(us.ctx, "udp read error, remote:%s, err:%v", us.addr, err)
+		return
+	}
+	atomic.StoreInt32(&us.active, 1)
+	if h.HasFlag(FlagStream) {
+		genLogger.Write(us.ctx, "udp read error, remote:%s, err:%v", us.addr, ErrNotStream)
+		return
+	}
+	c := s.pool.Get().(*Context)
+	c.Build(us.ctx, us)
+	c.header = h
+	c.body = b
+	c.checksum = s.checksum
+	c.echo = s.echoHeader
+	if !us.authed {
+		us.authenticate(c)
+		return
+	}
+	c.principal = us.principal
+	if spCtx != nil {
+		c.ctx = withRemoteSpanContext(c.ctx, spCtx)
+	}
+	if h.IsWithTraceContext() {
+		c.ctx = extractTrace(c.ctx, h.values)
+	}
+	c.readDeadline()
+	c.bindRoute(s.findRoute(h.GetID()))
+	c.handle(s)
+}
+
+// authenticate 鉴权前只接受鉴权帧及心跳, 失败不断开, 对端可重试直到会话超时
+func (us *udpSession) authenticate(c *Context) {
+	defer us.server.release(c)
+
+	id := c.header.GetID()
+	if id == PingID {
+		c.Write(PingID, nil)
+		return
+	}
+	if id != AuthID {
+		c.WriteError(id, ErrUnauthenticated)
+		return
+	}
+	principal, err := us.server.auth.Authenticate(c.Ctx(), c.header.values, c.body.buf)
+	if err != nil {
+		genLogger.Write(us.ctx, "udp auth error, remote:%s, err:%v", us.addr, err)
+		if _, ok := code.FromError(err); !ok {
+			err = ErrUnauthenticated
+		}
+		c.WriteError(AuthID, err)
+		return
+	}
+	us.principal = principal
+	us.authed = true
+	c.Write(AuthID, nil)
+}
+
+func (us *udpSession) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (us *udpSession) Write(p []byte) (int, error) {
+	if len(p) > MaxDatagramSize {
+		return 0, ErrDatagramTooLarge
+	}
+	return us.pc.WriteTo(p, us.addr)
+}
+
+func (us *udpSession) Close() error {
+	us.cancel()
+	return nil
+}
+
+func (us *udpSession) LocalAddr() net.Addr {
+	return us.pc.LocalAddr()
+}
+
+func (us *udpSession) RemoteAddr() net.Addr {
+	return us.addr
+}
+
+func (us *udpSession) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (us *udpSession) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (us *udpSession) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// DialUDP 以数据报模式连接 Engine, 不支持流式请求
+func DialUDP(addr string, opts ...ClientOption) (*Client, error) {
+	c, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(&datagramConn{Conn: c}, opts...), nil
+}
+
+// datagramConn 每次读取完整的数据报, 供按字节流解码
+type datagramConn struct {
+	net.Conn
+	buf []byte
+	r   bytes.Reader
+}
+
+func (c *datagramConn) Read(p []byte) (int, error) {
+	for c.r.Len() == 0 {
+		if c.buf == nil {
+			c.buf = make([]byte, MaxDatagramSize)
+		}
+		n, err := c.Conn.Read(c.buf)
+		if err != nil {
+			return 0, err
+		}
+		c.r.Reset(c.buf[:n])
+	}
+	return c.r.Read(p)
+}
+
+func (c *datagramConn) Write(p []byte) (int, error) {
+	if len(p) > MaxDatagramSize {
+		return 0, ErrDatagramTooLarge
+	}
+	return c.Conn.Write(p)
+}
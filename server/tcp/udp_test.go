@@ -0,0 +1,123 @@
+package tcp_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/ousanki/sagittarius/server/tcp"
+	"net"
+	"testing"
+	"time"
+)
+
+type addReq struct {
+	A int `json:"a"`
+}
+
+type addResp struct {
+	B int `json:"b"`
+}
+
+func add(c *tcp.Context, req addReq) (addResp, error) {
+	return addResp{B: req.A + 1}, nil
+}
+
+// rawHeader 与线上 headerBase 布局一致
+type rawHeader struct {
+	Magic     uint16
+	Version   uint8
+	Flag      uint8
+	WithTrace int8
+	Len       int64
+	ID        int64
+}
+
+func TestUDPDropsBadLength(t *testing.T) {
+	e := tcp.NewApp("udp")
+	e.WithOptions(tcp.SetAuthenticator(tcp.AuthenticatorFunc(func(ctx context.Context, header map[string]interface{}, body []byte) (interface{}, error) {
+		return "user", nil
+	}), time.Second))
+	id := e.HandleNamed("svc.Add", add)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Stop()
+	go e.ServePacket(pc)
+
+	// 鉴权前的数据报声明超大或负数长度
+	raw, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	for _, n := range []int64{1 << 62, -1} {
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.BigEndian, rawHeader{Magic: tcp.Magic, Version: tcp.ProtoVersion, Len: n, ID: tcp.AuthID})
+		raw.Write(buf.Bytes())
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, rawHeader{Magic: tcp.Magic, Version: tcp.ProtoVersion, Len: 2, ID: tcp.AuthID})
+	buf.WriteString("{}")
+	binary.Write(&buf, binary.BigEndian, int64(1<<40))
+	raw.Write(buf.Bytes())
+
+	cl, err := tcp.DialUDP(pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = cl.Authenticate(ctx, nil, nil); err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	var resp addResp
+	if err = cl.Call(ctx, id, addReq{A: 1}, &resp); err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	if resp.B != 2 {
+		t.Fatalf("want 2, got %d", resp.B)
+	}
+}
+
+func TestUDPMaxSessions(t *testing.T) {
+	e := tcp.NewApp("udp")
+	e.WithOptions(tcp.SetUDPMaxSessions(1), tcp.SetUDPSessionTimeout(200*time.Millisecond))
+	id := e.HandleNamed("svc.Add", add)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Stop()
+	go e.ServePacket(pc)
+
+	call := func(cl *tcp.Client, timeout time.Duration) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return cl.Call(ctx, id, addReq{A: 1}, nil)
+	}
+	a, err := tcp.DialUDP(pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := tcp.DialUDP(pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if err = call(a, time.Second); err != nil {
+		t.Fatalf("first peer: %v", err)
+	}
+	// 会话数已达上限, 新对端的数据报被丢弃
+	if err = call(b, 100*time.Millisecond); err == nil {
+		t.Fatal("want second peer dropped")
+	}
+	// 空闲会话释放后新对端可用
+	time.Sleep(400 * time.Millisecond)
+	if err = call(b, time.Second); err != nil {
+		t.Fatalf("second peer after eviction: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package tcp
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// _wsFrameOverhead 帧中不计入 maxFrameSize 的部分: 定长头、流头、trace 及校验和
+const _wsFrameOverhead = 64 << 10
+
+// WebSocketHandler 浏览器通过 WebSocket 二进制消息收发与 tcp 相同的帧,
+// 共用路由及中间件
+func (s *Engine) WebSocketHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := s.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			genLogger.Write(r.Context(), "websocket upgrade error, remote:%s, err:%v", r.RemoteAddr, err)
+			return
+		}
+		// 单条消息为一帧, 超出上限时断开
+		ws.SetReadLimit(s.maxFrameSize + _wsFrameOverhead)
+		s.ServeConn(&wsConn{ws: ws})
+	})
+}
+
+// RunWebSocket 在 port 上监听 WebSocket, path 为升级路径
+func (s *Engine) RunWebSocket(port string, path string) error {
+	mux := http.NewServeMux()
+	mux.Handle(path, s.WebSocketHandler())
+	return http.ListenAndServe(fmt.Sprintf("0.0.0.0:%s", port), mux)
+}
+
+// wsConn 将 WebSocket 适配为字节流, 每次 Write 为一条二进制消息
+type wsConn struct {
+	ws  *websocket.Conn
+	r   io.Reader
+	wmu sync.Mutex
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for {
+		if c.r == nil {
+			mt, r, err := c.ws.NextReader()
+			if err != nil {
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					return 0, io.EOF
+				}
+				return 0, io.ErrUnexpectedEOF
+			}
+			if mt != websocket.BinaryMessage {
+				continue
+			}
+			c.r = r
+		}
+		n, err := c.r.Read(p)
+		if err == io.EOF {
+			c.r = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := c.ws.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) Close() error {
+	return c.ws.Close()
+}
+
+func (c *wsConn) LocalAddr() net.Addr {
+	return c.ws.LocalAddr()
+}
+
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.ws.RemoteAddr()
+}
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.ws.SetWriteDeadline(t)
+}
+
+func (c *wsConn) SetReadDeadline(t time.Time) error {
+	return c.ws.SetReadDeadline(t)
+}
+
+func (c *wsConn) SetWriteDeadline(t time.Time) error {
+	return c.ws.SetWriteDeadline(t)
+}
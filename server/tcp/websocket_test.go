@@ -0,0 +1,83 @@
+package tcp_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/gorilla/websocket"
+	"github.com/ousanki/sagittarius/server/tcp"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebSocketReadLimit(t *testing.T) {
+	e := tcp.NewApp("tcp")
+	e.WithOptions(tcp.SetMaxFrameSize(1 << 10))
+	id := e.HandleNamed("svc.Add", add)
+	srv := httptest.NewServer(e.WebSocketHandler())
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	if err = ws.WriteMessage(websocket.BinaryMessage, encodeFrame(t, id, addReq{A: 1})); err != nil {
+		t.Fatal(err)
+	}
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err = ws.ReadMessage(); err != nil {
+		t.Fatalf("want reply, got %v", err)
+	}
+
+	// 超出上限的消息断开连接
+	ws.WriteMessage(websocket.BinaryMessage, make([]byte, 128<<10))
+	if _, _, err = ws.ReadMessage(); err == nil {
+		t.Fatal("want connection closed")
+	}
+}
+
+// encodeFrame 经 net.Pipe 编码一帧
+func encodeFrame(t *testing.T, id int64, data interface{}) []byte {
+	t.Helper()
+	a, b := net.Pipe()
+	go tcp.Write(context.Background(), tcp.UnUseTracer, nil, id, data, a)
+	f, err := tcp.DecodeFrame(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.Raw
+}
+
+func TestWebSocketAdminLoopback(t *testing.T) {
+	e := tcp.NewApp("tcp")
+	e.WithOptions(tcp.SetAdmin(tcp.AdminLoopback))
+	srv := httptest.NewServer(e.WebSocketHandler())
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// 对端虽为回环地址, WebSocket 连接仍被拒绝
+	if err = ws.WriteMessage(websocket.BinaryMessage, encodeFrame(t, tcp.AdminConnsID, nil)); err != nil {
+		t.Fatal(err)
+	}
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	_, msg, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := tcp.DecodeFrame(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := f.Header[tcp.HeaderCode]; c != float64(-4) {
+		t.Fatalf("want ErrForbidden, got %v", c)
+	}
+}